@@ -1,9 +1,15 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/rs/zerolog/log"
 
 	"github.com/OhohLeo/hifi-baby/audio"
+	"github.com/OhohLeo/hifi-baby/backup"
 	"github.com/OhohLeo/hifi-baby/http"
 	"github.com/OhohLeo/hifi-baby/raspberry"
 	"github.com/OhohLeo/hifi-baby/settings"
@@ -15,10 +21,15 @@ type App struct {
 	Audio    *audio.Audio
 	Gpio     *raspberry.Gpio
 	Database *sql.Database
+	Backups  *backup.Manager
+
+	shutdownTimeout   time.Duration
+	retentionDays     int           // retentionDays is the number of days listened tracks are kept.
+	retentionInterval time.Duration // retentionInterval is the interval between two runs of the retention policy.
 }
 
 // NewApp creates a new application instance with initialized components.
-func NewApp(cfg *Config, settings *settings.Settings) (*App, error) {
+func NewApp(cfg *Config, store *settings.Store) (*App, error) {
 	database, err := sql.NewDatabase(cfg.Database)
 	if err != nil {
 		return nil, err
@@ -26,56 +37,173 @@ func NewApp(cfg *Config, settings *settings.Settings) (*App, error) {
 
 	audioInstance, err := audio.NewAudio(
 		cfg.Audio,
-		settings.Audio,
+		store.Get().Audio,
 		database,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	server := http.NewServer(audioInstance, cfg.Server, settings, database)
+	// Apply the updated settings to the running player
+	store.Subscribe(func(updated settings.Settings) {
+		audioInstance.ApplySettings(updated.Audio)
+	})
+
+	backups := backup.NewManager(cfg.Backup, cfg.Audio, cfg.Database, audioInstance, database, store)
+
+	server := http.NewServer(audioInstance, cfg.Server, store, database, backups)
 
 	app := &App{
 		Server:   server,
 		Audio:    audioInstance,
 		Gpio:     raspberry.NewGpio("gpiochip0", 16),
 		Database: database,
+		Backups:  backups,
+
+		shutdownTimeout:   cfg.ShutdownTimeout,
+		retentionDays:     cfg.Database.RetentionDays,
+		retentionInterval: cfg.Database.RetentionInterval,
 	}
 
 	return app, nil
 }
 
-func (app *App) listenToGpio() {
+// listenToGpio handles the button actions until the context is done
+// and the GPIO line is released.
+func (app *App) listenToGpio(ctx context.Context) {
 	actions := make(chan string)
-	defer close(actions)
+	done := make(chan struct{})
 
 	go func() {
-		err := app.Gpio.Listen(actions)
+		defer close(done)
+		err := app.Gpio.Listen(ctx, actions)
 		if err != nil {
 			log.Error().Err(err).Msg("Error listening to GPIO events")
 		}
 	}()
 
-	for action := range actions {
-		log.Info().Msgf("Action: %v", action)
-		switch action {
-		case raspberry.StopMusic:
-			app.Audio.Stop()
-		case raspberry.ChangeMusic:
-			app.Audio.PlayRandomTrack()
+	for {
+		select {
+		case action := <-actions:
+			log.Info().Msgf("Action: %v", action)
+			switch action {
+			case raspberry.StopMusic:
+				app.Audio.Stop(audio.EndStopped)
+			case raspberry.ChangeMusic:
+				if err := app.Audio.PlayRandomTrack(audio.SourceGPIO); err != nil {
+					log.Error().Err(err).Msg("Error playing a random track")
+				}
+			case raspberry.FavouriteMusic:
+				// Without a playing track, the long press toggles the night mode
+				if app.Audio.GetPlayerState().CurrentTrack == nil {
+					app.Audio.ToggleNightMode()
+				} else if _, err := app.Audio.ToggleFavourite(); err != nil {
+					log.Error().Err(err).Msg("Error marking the track as favourite")
+				}
+			case raspberry.NextProfile:
+				if profile, err := app.Audio.NextProfile(); err != nil {
+					log.Error().Err(err).Msg("Error switching the profile")
+				} else {
+					log.Info().Msgf("Profile %q activated", profile.Name)
+				}
+			}
+		case <-done:
+			return
 		}
-
 	}
 }
 
-// Run starts the HTTP server and the audio manager.
-func (app *App) Run() error {
+// Run starts the HTTP server, the audio manager and the GPIO listener until
+// the context is done or the server fails, then shuts them down gracefully.
+func (app *App) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// Start listening to GPIO events
-	go app.listenToGpio()
+	gpioDone := make(chan struct{})
+	go func() {
+		defer close(gpioDone)
+		app.listenToGpio(ctx)
+	}()
+
+	// Apply the history retention policy in the background
+	retentionDone := make(chan struct{})
+	go func() {
+		defer close(retentionDone)
+		app.runRetention(ctx)
+	}()
+
+	// Write the scheduled backups in the background
+	backupDone := make(chan struct{})
+	go func() {
+		defer close(backupDone)
+		app.Backups.Run(ctx)
+	}()
 
 	// Start the audio management in a goroutine to run it concurrently
-	go app.Audio.Run()
+	go app.Audio.Run(ctx)
 
 	// Start the HTTP server using the Run() method of Server
-	return app.Server.Run()
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- app.Server.Run()
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Info().Msg("Shutdown requested")
+	case err = <-serverErr:
+		if err != nil {
+			err = fmt.Errorf("server failed: %w", err)
+		}
+	}
+	cancel()
+
+	return errors.Join(err, app.shutdown(gpioDone, retentionDone, backupDone))
+}
+
+// shutdown stops the components within the shutdown timeout: the server
+// completes its requests while the track fades out and the listen is recorded,
+// then the GPIO line is released, the retention and backup jobs complete and
+// the database is closed.
+func (app *App) shutdown(gpioDone, retentionDone, backupDone <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := app.Server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("server shutdown: %w", err))
+	}
+
+	select {
+	case <-app.Audio.Done():
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("audio shutdown: %w", ctx.Err()))
+	}
+
+	select {
+	case <-gpioDone:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("gpio shutdown: %w", ctx.Err()))
+	}
+
+	select {
+	case <-retentionDone:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("retention shutdown: %w", ctx.Err()))
+	}
+
+	select {
+	case <-backupDone:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("backup shutdown: %w", ctx.Err()))
+	}
+
+	if err := app.Database.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("database close: %w", err))
+	}
+
+	log.Info().Msg("Shutdown complete")
+	return errors.Join(errs...)
 }
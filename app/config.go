@@ -1,28 +1,115 @@
 package app
 
 import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
 	"github.com/Netflix/go-env"
 
 	"github.com/OhohLeo/hifi-baby/audio"
+	"github.com/OhohLeo/hifi-baby/backup"
 	"github.com/OhohLeo/hifi-baby/http"
+	"github.com/OhohLeo/hifi-baby/settings"
 	"github.com/OhohLeo/hifi-baby/sql"
 )
 
 type Config struct {
 	Audio    audio.Config
+	Backup   backup.Config
 	Database sql.Config
 	Server   http.Config
+	Settings settings.Config
+
+	LogLevel string `env:"LOG_LEVEL,default=info"`
+
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT,default=10s"` // ShutdownTimeout bounds the graceful shutdown.
 
-	LogLevel     string `env:"LOG_LEVEL,default=info"`
-	SettingsPath string `env:"SETTINGS_PATH,default=settings.json"`
+	PrintConfig bool               // PrintConfig prints the effective configuration and exits.
+	Overrides   settings.Overrides // Overrides are the settings given through the environment or the command line.
+
+	sources map[string]settings.Source // sources tells where each value comes from, by environment variable.
 }
 
-func NewConfig() (*Config, error) {
+// NewConfig reads the configuration from the environment, then from the command
+// line arguments which take precedence: every variable, including the settings,
+// can be given as a flag, LOG_LEVEL being set with --log-level.
+func NewConfig(args []string) (*Config, error) {
 	var cfg Config
 	_, err := env.UnmarshalFromEnviron(&cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	cfg.sources = make(map[string]settings.Source)
+	cfg.Overrides = settings.EnvOverrides()
+
+	flags := flag.NewFlagSet("hifi-baby", flag.ContinueOnError)
+	flags.BoolVar(&cfg.PrintConfig, "print-config", false, "print the effective configuration with the source of each value, then exit")
+
+	for _, field := range settings.Fields(&cfg) {
+		cfg.sources[field.Env] = settings.SourceDefault
+		if _, ok := os.LookupEnv(field.Env); ok {
+			cfg.sources[field.Env] = settings.SourceEnv
+		}
+
+		flags.Func(field.Flag(), flagUsage(field), func(value string) error {
+			cfg.sources[field.Env] = settings.SourceFlag
+			return field.Set(value)
+		})
+	}
+
+	// The settings flags are checked here and applied when loading the settings
+	var scratch settings.Settings
+	for _, field := range settings.Fields(&scratch) {
+		flags.Func(field.Flag(), flagUsage(field), func(value string) error {
+			if err := field.Set(value); err != nil {
+				return err
+			}
+			cfg.Overrides[field.Env] = settings.Override{Value: value, Source: settings.SourceFlag}
+			return nil
+		})
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
+
+func flagUsage(field *settings.Field) string {
+	if field.Default == "" {
+		return "same as the " + field.Env + " variable"
+	}
+	return fmt.Sprintf("same as the %s variable (default %s)", field.Env, field.Default)
+}
+
+// Print writes the effective configuration and settings with the source of each value.
+func (cfg *Config) Print(w io.Writer, store *settings.Store) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIABLE\tVALUE\tSOURCE")
+
+	for _, field := range settings.Fields(cfg) {
+		value := field.Value()
+		// Secrets are only shown as set
+		if strings.Contains(field.Env, "TOKEN") && value != "" {
+			value = "********"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", field.Env, value, cfg.sources[field.Env])
+	}
+
+	for _, field := range store.Fields() {
+		source := string(field.Source)
+		if field.Source == settings.SourceFile {
+			source += " " + store.Path()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", field.Env, field.Value(), source)
+	}
+
+	return tw.Flush()
+}
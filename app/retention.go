@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runRetention applies the history retention policy at startup, then at every
+// interval until the context is done.
+func (app *App) runRetention(ctx context.Context) {
+	if app.retentionDays <= 0 || app.retentionInterval <= 0 {
+		log.Info().Msg("History retention disabled: listened tracks are kept forever")
+		return
+	}
+
+	ticker := time.NewTicker(app.retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		app.pruneHistory()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pruneHistory rolls up the listened tracks of the days older than the retention period.
+func (app *App) pruneHistory() {
+	now := time.Now()
+	// Whole days are rolled up, in the local time zone
+	before := time.Date(now.Year(), now.Month(), now.Day()-app.retentionDays, 0, 0, 0, 0, time.Local)
+
+	result, err := app.Database.PruneHistory(before)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to prune the listening history")
+		return
+	}
+	if result.RolledUp > 0 {
+		log.Info().Msgf("History pruned: %d listened tracks before %s rolled up", result.RolledUp, before.Format(time.DateOnly))
+	}
+}
@@ -1,72 +1,189 @@
 package audio
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"math/rand"
-	"mime/multipart"
+	"math"
 	"os"
-	"path"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gopxl/beep"
 	"github.com/gopxl/beep/effects"
-	"github.com/gopxl/beep/speaker"
 	"github.com/rs/zerolog/log"
 )
 
 type Config struct {
-	StoragePath string `env:"STORAGE_PATH,default=tracks"`
+	StoragePath     string        `env:"STORAGE_PATH,default=tracks"`
+	MaxTrackSize    int64         `env:"TRACK_MAX_SIZE,default=209715200"`     // MaxTrackSize is the maximum size in bytes of an uploaded track.
+	MaxImportSize   int64         `env:"IMPORT_MAX_SIZE,default=2147483648"`   // MaxImportSize is the maximum size in bytes extracted from an archive.
+	SampleRate      int           `env:"AUDIO_SAMPLE_RATE,default=44100"`      // SampleRate is the sample rate of the audio output.
+	TranscodeFormat string        `env:"TRANSCODE_FORMAT"`                     // TranscodeFormat is the canonical format (wav or flac) imported tracks are converted to, if set.
+	Output          string        `env:"AUDIO_OUTPUT,default=speaker"`         // Output is where the tracks are played: speaker, null or file.
+	OutputFile      string        `env:"AUDIO_OUTPUT_FILE,default=output.wav"` // OutputFile is the WAV file recording the playback of the file output.
+	FadeOutDuration time.Duration `env:"AUDIO_FADE_OUT,default=2s"`            // FadeOutDuration is the fade-out of the playing track on shutdown.
+	Crossfade       time.Duration `env:"AUDIO_CROSSFADE,default=0s"`           // Crossfade is how long a replaced track fades out under the next one, 0 to cut it.
 }
 
 type Settings struct {
-	BaseVolume    float64 `json:"base_volume"`
-	DefaultVolume float64 `json:"default_volume"`
-	MinVolume     float64 `json:"min_volume"`
-	MaxVolume     float64 `json:"max_volume"`
-	VolumeStep    float64 `json:"volume_step"`
-	SilentEnabled bool    `json:"silent_enabled"`
+	BaseVolume     float64 `json:"base_volume" env:"AUDIO_BASE_VOLUME,default=10"`
+	DefaultVolume  float64 `json:"default_volume" env:"AUDIO_DEFAULT_VOLUME,default=-0.5"`
+	MinVolume      float64 `json:"min_volume" env:"AUDIO_MIN_VOLUME,default=-2"`
+	MaxVolume      float64 `json:"max_volume" env:"AUDIO_MAX_VOLUME,default=5"`
+	VolumeStep     float64 `json:"volume_step" env:"AUDIO_VOLUME_STEP,default=0.5"`
+	SilentEnabled  bool    `json:"silent_enabled" env:"AUDIO_SILENT_ENABLED,default=false"`
+	FavouritesOnly bool    `json:"favourites_only" env:"AUDIO_FAVOURITES_ONLY,default=false"` // FavouritesOnly restricts the random play to the favourite tracks.
+	WeightedRandom bool    `json:"weighted_random" env:"AUDIO_WEIGHTED_RANDOM,default=false"` // WeightedRandom favours the best rated tracks in the random play.
+
+	EQ        EQ         `json:"eq"`         // EQ is the equalizer applied to the playback.
+	EQPresets []EQPreset `json:"eq_presets"` // EQPresets are the named equalizer settings.
+
+	Night     NightSettings     `json:"night"`     // Night softens the sound in night mode.
+	Generator GeneratorSettings `json:"generator"` // Generator holds the defaults of the sound generators.
 }
 
 type Capabilities interface {
-	AddListenedTrack(track *Track, when time.Time, during int64) error
+	// RegisterTrack assigns the track its persistent ID, reusing the one
+	// already known for its path or, when the file moved, for its fingerprint.
+	RegisterTrack(track *Track) error
+	// AddListenedTrack records a playback in the listening history.
+	AddListenedTrack(listen *Listen) error
+	// RateTrack saves the rating of the track.
+	RateTrack(id uuid.UUID, rating Rating) error
+	// SetTrackAvailability saves when the track may be played.
+	SetTrackAvailability(id uuid.UUID, availability Availability) error
+	// Profiles returns the profiles of the children.
+	Profiles() ([]*Profile, error)
+	// ActiveProfile returns the active profile, nil if none.
+	ActiveProfile() (*Profile, error)
+	// ActivateProfile saves the active profile and returns it, nil for uuid.Nil.
+	ActivateProfile(id uuid.UUID) (*Profile, error)
+	// ListeningTime returns the time the profile has spent listening since the given time.
+	ListeningTime(profileID uuid.UUID, since time.Time) (time.Duration, error)
 }
 
+var (
+	ErrTrackNotFound = errors.New("track not found")
+	ErrNoTrack       = errors.New("no track available")
+	ErrClosed        = errors.New("audio manager is closed")
+)
+
 // Audio manages a list of audio tracks, playback state, volume control, and storage path.
+//
+// The audio state is owned by the Run goroutine: the exported methods send it
+// commands and wait for their replies, so they are safe for concurrent use.
+// Registered tracks are never modified, updates replace them.
 type Audio struct {
-	tracks       map[uuid.UUID]*Track // tracks holds a slice of all available tracks.
-	activeStream *beep.Ctrl           // ctrlStream controls the pause and resume of the active stream
-	volume       *effects.Volume      // volume controls the volume of the playback.
-	storagePath  string               // storagePath is the base path where audio files are stored.
-	playRequests chan uuid.UUID       // playRequests is a channel for play requests
-	stopChan     chan bool            // stopChan is a channel to signal stop
-	playerState  PlayerState          // playerState holds the current state of the audio player.
-	settings     Settings             // settings holds the audio player settings.
-	capabilities Capabilities
-}
-
-// NewAudio creates a new Audio instance with a given list of track paths and a storage path.
+	storagePath     string          // storagePath is the base path where audio files are stored.
+	maxTrackSize    int64           // maxTrackSize is the maximum size in bytes of an uploaded track.
+	maxImportSize   int64           // maxImportSize is the maximum size in bytes extracted from an archive.
+	storeMutex      sync.Mutex      // storeMutex serialises the choice of names for stored tracks.
+	sampleRate      beep.SampleRate // sampleRate is the sample rate of the audio output.
+	transcodeFormat string          // transcodeFormat is the canonical format of imported tracks, empty to keep them as is.
+	fadeOutDuration time.Duration   // fadeOutDuration is the fade-out of the playing track on shutdown.
+	crossfade       time.Duration   // crossfade is how long a replaced track fades out under the next one.
+	capabilities    Capabilities
+	output          Output // output is where the tracks are played.
+
+	commands  chan command  // commands is the channel of the requests executed by Run.
+	quit      chan struct{} // quit is closed to stop Run.
+	done      chan struct{} // done is closed once Run has returned.
+	closeOnce sync.Once
+	started   atomic.Bool // started is set by the first call to Run, or by Close when Run was never called.
+
+	// The following fields are only accessed by the Run goroutine.
+	tracks      map[uuid.UUID]*Track // tracks holds all available tracks.
+	volume      *effects.Volume      // volume controls the volume of the playback.
+	equalizer   *equalizer           // equalizer shapes the tone of the playback, before the compressor.
+	compressor  *compressor          // compressor evens the sound in night mode, before the volume.
+	playback    *playback            // playback is the track being played, nil if none.
+	tail        *tail                // tail is the replaced track fading out under the playback, nil if none.
+	playbackID  int                  // playbackID identifies the last started playback.
+	playerState PlayerState          // playerState holds the current state of the audio player.
+	settings    Settings             // settings holds the audio player settings.
+	profile     *Profile             // profile is the active profile, nil if none.
+	generator   *generatorPlayback   // generator is the sound generator played under the music, nil if none.
+
+	nightMode      bool // nightMode tells whether the night mode is enabled.
+	nightScheduled bool // nightScheduled tells whether the night schedule was running at the last check.
+}
+
+// playback holds the resources of the track being played.
+type playback struct {
+	id        int                   // id identifies the playback, to ignore late end notifications.
+	track     *Track                // track is the track being played.
+	file      *os.File              // file is the opened track file.
+	streamer  beep.StreamSeekCloser // streamer decodes the track file.
+	format    beep.Format           // format is the format of the decoded track.
+	looper    *looper               // looper repeats the track, or a section of it, following the loop mode.
+	ctrl      *beep.Ctrl            // ctrl controls the pause and resume of the stream.
+	output    *beep.Ctrl            // output detaches the playback from the output once stopped, leaving the generator.
+	startTime time.Time             // startTime is when the playback started.
+	source    Source                // source tells what started the playback.
+	startPos  int                   // startPos is the position in samples at the start of the playback.
+	deadline  time.Time             // deadline is when the listening quota of the profile is reached, zero if unlimited.
+}
+
+// NewAudio creates a new Audio instance with a given list of track paths and a storage path,
+// playing on the output selected in the configuration.
 func NewAudio(
 	config Config,
 	settings Settings,
 	capabilities Capabilities,
+) (*Audio, error) {
+	if _, ok := canonicalFormats[config.TranscodeFormat]; config.TranscodeFormat != "" && !ok {
+		return nil, fmt.Errorf("unsupported transcode format %q, expected wav or flac", config.TranscodeFormat)
+	}
+
+	output, err := NewOutput(config)
+	if err != nil {
+		return nil, err
+	}
+
+	audio, err := NewAudioWithOutput(config, settings, capabilities, output)
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+
+	return audio, nil
+}
+
+// NewAudioWithOutput creates a new Audio instance playing on the given output.
+func NewAudioWithOutput(
+	config Config,
+	settings Settings,
+	capabilities Capabilities,
+	output Output,
 ) (*Audio, error) {
 	storagePath := config.StoragePath
 	audio := &Audio{
-		tracks: make(map[uuid.UUID]*Track),
 		volume: &effects.Volume{
 			Base:   settings.BaseVolume,
 			Volume: settings.DefaultVolume,
 			Silent: settings.SilentEnabled,
 		},
-		storagePath:  storagePath,
-		playRequests: make(chan uuid.UUID),
-		stopChan:     make(chan bool),
-		settings:     settings,
-		capabilities: capabilities,
+		equalizer:       &equalizer{eq: settings.EQ},
+		compressor:      &compressor{},
+		storagePath:     storagePath,
+		maxTrackSize:    config.MaxTrackSize,
+		maxImportSize:   config.MaxImportSize,
+		sampleRate:      beep.SampleRate(config.SampleRate),
+		transcodeFormat: config.TranscodeFormat,
+		fadeOutDuration: config.FadeOutDuration,
+		crossfade:       config.Crossfade,
+		commands:        make(chan command),
+		quit:            make(chan struct{}),
+		done:            make(chan struct{}),
+		settings:        settings,
+		capabilities:    capabilities,
+		output:          output,
 	}
 
 	// Ensure the directory exists or create it
@@ -76,8 +193,30 @@ func NewAudio(
 		}
 	}
 
+	// Run is not started yet: the tracks and the profile can be set directly
+	tracks, err := audio.scanTracks(true)
+	if err != nil {
+		return nil, err
+	}
+	audio.tracks = tracks
+
+	if audio.profile, err = capabilities.ActiveProfile(); err != nil {
+		return nil, fmt.Errorf("failed to load the active profile: %w", err)
+	}
+	audio.playerState.Profile = audio.profile
+	audio.playerState.Loop = Loop{Mode: LoopOff}
+	audio.clampVolume()
+
+	return audio, nil
+}
+
+// scanTracks loads and registers the tracks of the storage path.
+// Incomplete uploads are skipped, or removed if removeIncomplete is set.
+func (a *Audio) scanTracks(removeIncomplete bool) (map[uuid.UUID]*Track, error) {
+	tracks := make(map[uuid.UUID]*Track)
+
 	// Lire tous les fichiers .mp3 et .wav dans le répertoire storagePath
-	err := filepath.Walk(storagePath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(a.storagePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -85,11 +224,22 @@ func NewAudio(
 			return nil
 		}
 
+		// Remove uploads interrupted before completion
+		if strings.HasPrefix(info.Name(), uploadTempPrefix) {
+			if !removeIncomplete {
+				return nil
+			}
+			log.Warn().Msgf("Removing incomplete upload %s", path)
+			return os.Remove(path)
+		}
+
 		ext := filepath.Ext(path)
 		if isSupportedFormat(ext) {
-			if _, err := audio.addTrack(path); err != nil {
+			track, err := a.loadTrack(path)
+			if err != nil {
 				return err
 			}
+			tracks[track.ID] = track
 		}
 
 		return nil
@@ -99,193 +249,363 @@ func NewAudio(
 		return nil, err
 	}
 
-	// Initialisation du haut-parleur avec le format décodé
-	sampleRate := beep.SampleRate(44100)
-	if err := speaker.Init(sampleRate, sampleRate.N(time.Second/5)); err != nil {
-		return nil, fmt.Errorf("speaker issue : %v", err)
-	}
-
-	return audio, nil
+	return tracks, nil
 }
 
-// AddTrack appends a new track to the audio manager, determining its index based on the current list size.
-// It returns the newly created track and any error encountered.
-func (a *Audio) AddTrack(file multipart.File, header *multipart.FileHeader) (*Track, error) {
-	fullPath := filepath.Join(a.storagePath, header.Filename)
+// Rescan loads the tracks of the storage path and the active profile again,
+// such as after a restore: the tracks are registered anew and those whose file
+// is gone are dropped.
+func (a *Audio) Rescan() error {
+	tracks, err := a.scanTracks(false)
+	if err != nil {
+		return err
+	}
 
-	// Vérifier l'extension du fichier
-	ext := filepath.Ext(header.Filename)
-	if !isSupportedFormat(ext) {
-		return nil, fmt.Errorf("unsupported file type '%s'", ext)
+	profile, err := a.capabilities.ActiveProfile()
+	if err != nil {
+		return fmt.Errorf("failed to load the active profile: %w", err)
 	}
 
-	// Construire le chemin complet où le fichier sera sauvegardé
-	filePath := path.Join(a.storagePath, header.Filename)
+	a.do(func() {
+		a.tracks = tracks
+		a.setProfile(profile)
+	})
+	return nil
+}
 
-	// Créer le fichier sur le disque
-	out, err := os.Create(filePath)
+// addTrack loads the track stored at the given path and adds it to the available tracks.
+func (a *Audio) addTrack(path string) (*Track, error) {
+	track, err := a.loadTrack(path)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create the file '%s'", filePath)
+		return nil, err
 	}
-	defer out.Close()
 
-	// Copier le contenu du fichier téléchargé dans le nouveau fichier
-	if _, err := io.Copy(out, file); err != nil {
-		return nil, fmt.Errorf("failed to save the file '%s'", filePath)
+	reply := make(chan struct{}, 1)
+	if !a.send(addTrackCommand{track: track, reply: reply}) {
+		return nil, ErrClosed
 	}
+	<-reply
 
-	return a.addTrack(fullPath)
+	return track, nil
 }
 
-func (a *Audio) addTrack(path string) (*Track, error) {
+// loadTrack creates the track stored at the given path and registers it.
+func (a *Audio) loadTrack(path string) (*Track, error) {
 	newTrack, err := NewTrack(path)
 	if err != nil {
 		return nil, err
 	}
 
-	a.tracks[newTrack.ID] = newTrack
+	if dir, err := filepath.Rel(a.storagePath, filepath.Dir(path)); err == nil && dir != "." {
+		newTrack.Collection = filepath.ToSlash(dir)
+	}
+
+	if err := a.capabilities.RegisterTrack(newTrack); err != nil {
+		return nil, fmt.Errorf("failed to register track %q: %w", path, err)
+	}
+
 	return newTrack, nil
 }
 
 // RemoveTrack removes a track from the list by index and handles playback and file deletion.
 func (a *Audio) RemoveTrack(id uuid.UUID) error {
-	trackToRemove, ok := a.tracks[id]
-	if !ok {
-		return fmt.Errorf("track %q not found", id)
+	reply := make(chan error, 1)
+	if !a.send(removeTrackCommand{trackID: id, reply: reply}) {
+		return ErrClosed
+	}
+	return <-reply
+}
+
+// GetPlayerState returns the current state of the audio player.
+func (a *Audio) GetPlayerState() PlayerState {
+	reply := make(chan PlayerState, 1)
+	if !a.send(playerStateCommand{reply: reply}) {
+		return PlayerState{}
 	}
+	return <-reply
+}
 
-	// Stop playback if the track to be removed is currently playing.
-	if a.activeStream != nil && trackToRemove == a.playerState.CurrentTrack {
-		a.Stop()
+// GetTrack returns the track matching the given ID.
+func (a *Audio) GetTrack(id uuid.UUID) (*Track, bool) {
+	reply := make(chan *Track, 1)
+	if !a.send(getTrackCommand{trackID: id, reply: reply}) {
+		return nil, false
 	}
+	track := <-reply
+	return track, track != nil
+}
 
-	// Delete the track file from the filesystem.
-	if err := trackToRemove.Delete(); err != nil {
-		return err
+// Tracks returns a slice of all available tracks, followed by the virtual
+// tracks of the generators.
+func (a *Audio) Tracks() []*Track {
+	reply := make(chan []*Track, 1)
+	if !a.send(tracksCommand{reply: reply}) {
+		return nil
 	}
+	return <-reply
+}
 
-	// Remove the track from the list.
-	delete(a.tracks, id)
-	return nil
+// PlayRandomTrack selects a random track and plays it, on behalf of the given source.
+func (a *Audio) PlayRandomTrack(source Source) error {
+	reply := make(chan error, 1)
+	if !a.send(playRandomCommand{source: source, reply: reply}) {
+		return ErrClosed
+	}
+	return <-reply
 }
 
-// GetPlayerState returns the current state of the audio player.
-func (a *Audio) GetPlayerState() PlayerState {
-	return a.playerState
+// Play a specific track from the track list, on behalf of the given source.
+// Tracks chosen through the API are always played; for the other sources, such
+// as a card, the track must be available and allowed by the active profile.
+func (a *Audio) PlayTrack(trackID uuid.UUID, source Source) error {
+	reply := make(chan error, 1)
+	if !a.send(playCommand{trackID: trackID, source: source, reply: reply}) {
+		return ErrClosed
+	}
+	return <-reply
 }
 
-// Tracks returns a slice of all available tracks.
-func (a *Audio) Tracks() []*Track {
-	tracks := make([]*Track, len(a.tracks))
-	idx := 0
+// Pause the currently playing track if it is not already paused.
+func (a *Audio) Pause() {
+	a.do(func() { a.setPaused(true) })
+}
 
-	for _, track := range a.tracks {
-		tracks[idx] = track
-		idx++
-	}
+// Resume the playback of the currently paused track if it is paused.
+func (a *Audio) Resume() {
+	a.do(func() { a.setPaused(false) })
+}
 
-	sort.Slice(tracks, func(i, j int) bool {
-		return tracks[i].Name < tracks[j].Name
+// IncreaseVolume increases the audio volume.
+func (a *Audio) IncreaseVolume() {
+	a.do(func() { a.changeVolume(a.currentSettings().VolumeStep) })
+}
+
+// DecreaseVolume decreases the audio volume.
+func (a *Audio) DecreaseVolume() {
+	a.do(func() { a.changeVolume(-a.currentSettings().VolumeStep) })
+}
+
+// Mute mutes the currently playing audio.
+func (a *Audio) Mute(enable bool) {
+	a.do(func() {
+		a.output.Lock()
+		defer a.output.Unlock()
+
+		a.volume.Silent = enable
+		if a.generator != nil {
+			a.generator.volume.Silent = enable
+		}
+		a.playerState.IsMuted = enable
 	})
+}
 
-	return tracks
+// ApplySettings applies new settings to the running player.
+// The current volume is brought back within the new limits, and the night
+// mode follows its new settings.
+func (a *Audio) ApplySettings(settings Settings) {
+	a.do(func() {
+		a.output.Lock()
+		defer a.output.Unlock()
+
+		a.settings = settings
+		a.volume.Base = settings.BaseVolume
+		if a.generator != nil {
+			a.generator.volume.Base = settings.BaseVolume
+		}
+		a.equalizer.set(settings.EQ)
+		a.applyNightMode()
+	})
 }
 
-// PlayRandomTrack selects a random track and plays it.
-func (a *Audio) PlayRandomTrack() {
-	trackIDs := make([]uuid.UUID, len(a.tracks))
-	idx := 0
-	for trackID := range a.tracks {
-		trackIDs[idx] = trackID
-		idx++
-	}
+// PreviewEQ applies the equalizer to the playback without saving it in the settings.
+func (a *Audio) PreviewEQ(eq EQ) {
+	a.do(func() {
+		a.output.Lock()
+		defer a.output.Unlock()
+
+		a.equalizer.set(eq)
+	})
+}
 
-	randomIndex := rand.Intn(len(a.tracks))
-	a.PlayTrack(trackIDs[int(randomIndex)])
+// Stop any currently playing track and resets playback state.
+// The reason is recorded in the listening history.
+func (a *Audio) Stop(reason EndReason) {
+	a.do(func() { a.stopPlayback(reason) })
 }
 
-// Play a specific track from the track list based on the index.
-func (a *Audio) PlayTrack(trackID uuid.UUID) {
-	// Stop the currently playing track if it exists
-	if a.activeStream != nil {
-		a.Stop()
+// Run executes the commands sent to the audio manager until it is closed or
+// the context is done. On cancellation, the playing track fades out before
+// the playback is stopped and recorded.
+func (a *Audio) Run(ctx context.Context) {
+	// Close may have been called first, shutting the audio manager down
+	if !a.started.CompareAndSwap(false, true) {
+		return
+	}
+
+	log.Info().Msg("Audio manager started")
+	defer close(a.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case cmd := <-a.commands:
+			cmd.execute(a)
+		case <-ticker.C:
+			a.logPosition()
+			a.checkLimits()
+			a.checkNightSchedule()
+			a.checkGeneratorTimer()
+		case <-ctx.Done():
+			a.fadeOut()
+			a.shutdown()
+			return
+		case <-a.quit:
+			a.shutdown()
+			return
+		}
 	}
+}
+
+// Close stops the playback, the Run goroutine and the output, then waits for Run
+// to return. When Run was never called, the output is closed directly.
+func (a *Audio) Close() {
+	a.closeOnce.Do(func() {
+		close(a.quit)
+		if a.started.CompareAndSwap(false, true) {
+			a.shutdown()
+			close(a.done)
+		}
+	})
+	<-a.done
+}
 
-	if track, ok := a.tracks[trackID]; ok {
-		log.Info().Msgf("Playing track %s", track.Path)
+// Done returns a channel closed once Run has returned.
+func (a *Audio) Done() <-chan struct{} {
+	return a.done
+}
 
-		// Send the play request for the new track
-		a.playRequests <- track.ID
+// send queues the command to the Run goroutine.
+// It returns false if the audio manager is closed.
+func (a *Audio) send(cmd command) bool {
+	select {
+	case a.commands <- cmd:
+		return true
+	case <-a.quit:
+		return false
+	case <-a.done:
+		return false
 	}
 }
 
-// Pause the currently playing track if it is not already paused.
-func (a *Audio) Pause() {
-	if a.activeStream == nil || a.activeStream.Paused {
-		return
+// shutdown stops the playback and closes the output.
+func (a *Audio) shutdown() {
+	a.stopPlayback(EndStopped)
+	if err := a.output.Close(); err != nil {
+		log.Error().Err(err).Msg("Error closing the audio output")
 	}
-	speaker.Lock()
-	defer speaker.Unlock()
-	a.activeStream.Paused = true
-	a.playerState.IsPlaying = false
+	log.Info().Msg("Audio manager stopped")
 }
 
-// Resume the playback of the currently paused track if it is paused.
-func (a *Audio) Resume() {
-	if a.activeStream == nil || !a.activeStream.Paused {
+// fadeOut fades the playing track out and waits for the end of the fade.
+func (a *Audio) fadeOut() {
+	if a.playback == nil || a.playback.ctrl.Paused || a.fadeOutDuration <= 0 {
 		return
 	}
-	speaker.Lock()
-	defer speaker.Unlock()
-	a.activeStream.Paused = false
-	a.playerState.IsPlaying = true
-}
 
-// IncreaseVolume increases the audio volume.
-func (a *Audio) IncreaseVolume() {
-	speaker.Lock()
-	defer speaker.Unlock()
+	log.Info().Msgf("Fading out track: %s", a.playback.track.Path)
+
+	// The output may play late when busy: wait for the end of the fade
+	// rather than its duration, bounded in case the output stopped.
+	done := make(chan struct{})
+	a.output.Lock()
+	a.volume.Streamer = beep.Seq(
+		newFade(a.volume.Streamer, a.sampleRate.N(a.fadeOutDuration)),
+		beep.Callback(func() { close(done) }),
+	)
+	a.output.Unlock()
 
-	a.volume.Volume += a.settings.VolumeStep
-	if a.volume.Volume > a.settings.MaxVolume {
-		a.volume.Volume = a.settings.MaxVolume
+	select {
+	case <-done:
+	case <-time.After(2 * a.fadeOutDuration):
+		log.Warn().Msg("Fade-out not played in time")
 	}
 }
 
-// DecreaseVolume decreases the audio volume.
-func (a *Audio) DecreaseVolume() {
-	speaker.Lock()
-	defer speaker.Unlock()
+// do runs the function in the Run goroutine and waits for its completion.
+func (a *Audio) do(fn func()) {
+	reply := make(chan struct{}, 1)
+	if a.send(funcCommand{fn: fn, reply: reply}) {
+		<-reply
+	}
+}
 
-	a.volume.Volume -= a.settings.VolumeStep
-	if a.volume.Volume < a.settings.MinVolume {
-		a.volume.Volume = a.settings.MinVolume
+// sortedTracks returns all available tracks sorted by name.
+func (a *Audio) sortedTracks() []*Track {
+	tracks := make([]*Track, 0, len(a.tracks))
+	for _, track := range a.tracks {
+		tracks = append(tracks, track)
 	}
+
+	sort.Slice(tracks, func(i, j int) bool {
+		return tracks[i].Name < tracks[j].Name
+	})
+
+	return tracks
 }
 
-// Mute mutes the currently playing audio.
-func (a *Audio) Mute(enable bool) {
-	speaker.Lock()
-	defer speaker.Unlock()
+func (a *Audio) removeTrack(id uuid.UUID) error {
+	trackToRemove, ok := a.tracks[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrTrackNotFound, id)
+	}
+
+	// Stop playback if the track to be removed is currently playing.
+	if a.playback != nil && a.playback.track.ID == id {
+		a.stopPlayback(EndStopped)
+	}
+
+	// Delete the track file from the filesystem.
+	if err := trackToRemove.Delete(); err != nil {
+		return err
+	}
 
-	a.volume.Silent = enable
-	a.playerState.IsMuted = enable
+	// Remove the track from the list.
+	delete(a.tracks, id)
+	return nil
 }
 
-func (a *Audio) Run() {
-	log.Info().Msg("Audio manager started")
-	for index := range a.playRequests {
-		if err := a.playTrack(index); err != nil {
-			log.Error().Msgf("Error playing track: %v", err)
-			continue
-		}
+func (a *Audio) playRandomTrack(source Source) error {
+	trackID, err := a.randomTrack()
+	if err != nil {
+		return err
 	}
+
+	return a.playTrack(trackID, source)
 }
 
-func (a *Audio) playTrack(id uuid.UUID) error {
+// playTrack stops the current playback, if any, and starts playing the given track.
+// A virtual track starts its generator, without stopping the playback.
+// The availability of the track and the restrictions of the profile are only
+// bypassed when the track is chosen through the API.
+func (a *Audio) playTrack(id uuid.UUID, source Source) error {
+	// The virtual tracks of the generators are played under the music
+	if kind, ok := generatorByTrack(id); ok {
+		return a.startGenerator(kind, GeneratorOptions{})
+	}
+
 	track, ok := a.tracks[id]
 	if !ok {
-		return fmt.Errorf("track %q not found", id)
+		return fmt.Errorf("%w: %q", ErrTrackNotFound, id)
+	}
+
+	var remaining time.Duration
+	if source != SourceHTTP {
+		var err error
+		if remaining, err = a.canPlay(track); err != nil {
+			return err
+		}
 	}
 
 	// Open the track file
@@ -293,69 +613,196 @@ func (a *Audio) playTrack(id uuid.UUID) error {
 	if err != nil {
 		return fmt.Errorf("error opening file: %v", err)
 	}
-	defer file.Close()
 
 	// Decode the opened file
 	streamer, format, err := track.Decode(file)
 	if err != nil {
+		file.Close()
 		return fmt.Errorf("error during decoding: %v", err)
 	}
-	defer streamer.Close()
 
-	a.activeStream = &beep.Ctrl{Streamer: streamer, Paused: false}
-	a.volume.Streamer = a.activeStream
-	startTime := time.Now() // Start time of the track
+	// Skip the currently playing track if it exists, fading it out under the
+	// new one: a track that cannot be played does not interrupt it
+	tail := a.endPlayback(EndSkipped, a.sampleRate.N(a.crossfade))
+
+	a.playbackID++
+	playbackID := a.playbackID
+	looper := &looper{
+		Streamer: streamer,
+		// The looper runs in the output goroutine, with the output locked
+		wrapped: func(end, start int) {
+			go a.send(trackWrappedCommand{playbackID: playbackID, end: end, start: start})
+		},
+	}
+	current := &playback{
+		id:        playbackID,
+		track:     track,
+		file:      file,
+		streamer:  streamer,
+		format:    format,
+		looper:    looper,
+		ctrl:      &beep.Ctrl{Streamer: looper, Paused: false},
+		startTime: time.Now(),
+		source:    source,
+		startPos:  streamer.Position(),
+	}
+	if remaining > 0 {
+		current.deadline = current.startTime.Add(remaining)
+	}
+	a.playback = current
+
+	// The section of the previous track is not repeated
+	if a.playerState.Loop.Mode == LoopSection {
+		a.playerState.Loop = Loop{Mode: LoopOff}
+	}
+	a.applyLoop()
+
+	var input beep.Streamer = current.ctrl
+	if tail != nil {
+		input = beep.Mix(tail, newFadeIn(current.ctrl, tail.fade.total))
+	}
+
+	a.output.Lock()
+	a.tail = tail
+	a.equalizer.reset(input, format.SampleRate)
+	a.compressor.reset(a.equalizer, format.SampleRate)
+	a.volume.Streamer = a.compressor
+	a.output.Unlock()
+
 	a.playerState.InitializeTrack(
 		track,
 		format.SampleRate.D(streamer.Position()).Round(time.Second),
 		format.SampleRate.D(streamer.Len()).Round(time.Second),
 	)
-	defer func() {
-		a.activeStream = nil
-		a.volume.Streamer = nil
-		a.playerState.StopTrack()
-		duration := int64(time.Since(startTime).Seconds())
-
-		if err := a.capabilities.AddListenedTrack(track, startTime, duration); err != nil {
-			log.Error().Msgf("Error adding listened track: %v", err)
-		}
-	}()
 
-	log.Info().Msgf("Playing track: %s\n", track.Path)
+	log.Info().Msgf("Playing track: %s", track.Path)
 
-	speaker.Play(beep.Seq(a.volume, beep.Callback(func() {
-		a.stopChan <- true
-	})))
-	defer speaker.Clear()
+	// The callback runs in the output goroutine, with the output locked:
+	// the notification must not wait for the Run goroutine.
+	current.output = &beep.Ctrl{Streamer: beep.Seq(a.volume, beep.Callback(func() {
+		go a.send(trackEndedCommand{playbackID: current.id})
+	}))}
+	a.output.Play(current.output)
 
-	for {
-		select {
-		case <-a.stopChan:
-			a.playerState.StopTrack()
-			log.Info().Msgf("Stopped playing track: %s\n", track.Path)
-			return nil
-		case <-time.After(time.Second):
-			speaker.Lock()
-			if posStreamer, ok := streamer.(interface{ Position() int }); ok {
-				elapsedTime := format.SampleRate.D(posStreamer.Position()).Round(time.Second)
-				log.Info().Msgf("Position: %s", elapsedTime)
-			} else {
-				log.Error().Msg("The streamer does not support the Position method")
-			}
-			speaker.Unlock()
-		}
+	return nil
+}
+
+// stopPlayback stops the current playback, if any, and records it as listened
+// with the reason of its end.
+func (a *Audio) stopPlayback(reason EndReason) {
+	a.endPlayback(reason, 0)
+}
+
+// endPlayback stops the current playback, if any, and records it as listened
+// with the reason of its end. Unless paused, the track keeps playing during
+// the given number of samples as the returned tail, fading out, to be mixed
+// with the next playback. It returns nil without crossfade.
+func (a *Audio) endPlayback(reason EndReason, crossfade int) *tail {
+	current := a.playback
+	if current == nil {
+		return nil
+	}
+
+	release := func() {
+		current.streamer.Close()
+		current.file.Close()
 	}
+
+	a.output.Lock()
+	current.output.Streamer = nil
+	a.volume.Streamer = nil
+	a.equalizer.Streamer = nil
+	a.compressor.Streamer = nil
+	endPos := current.streamer.Position()
+	// A tail still fading out is cut
+	if a.tail != nil {
+		a.tail.close()
+		a.tail = nil
+	}
+	var fading *tail
+	if crossfade > 0 && !current.ctrl.Paused {
+		fading = &tail{fade: newFade(current.ctrl, crossfade), release: release}
+	}
+	a.output.Unlock()
+
+	if fading == nil {
+		release()
+	}
+
+	a.playback = nil
+	a.playerState.StopTrack()
+	log.Info().Msgf("Stopped playing track: %s", current.track.Path)
+
+	a.recordListen(current, reason, endPos)
+	return fading
 }
 
-// Stop any currently playing track and resets playback state.
-func (a *Audio) Stop() {
-	if a.activeStream != nil {
-		a.stopChan <- true // Send a signal to stop playback
+// recordListen records the playback as listened since its start, up to the
+// end position in samples.
+func (a *Audio) recordListen(current *playback, reason EndReason, endPos int) {
+	sampleRate := current.format.SampleRate
+	listen := &Listen{
+		Track:         current.track,
+		Profile:       a.profile,
+		Source:        current.source,
+		EndReason:     reason,
+		At:            current.startTime,
+		During:        time.Since(current.startTime),
+		StartPosition: sampleRate.D(current.startPos),
+		EndPosition:   sampleRate.D(endPos),
+		TrackDuration: sampleRate.D(current.streamer.Len()),
+	}
+	if err := a.capabilities.AddListenedTrack(listen); err != nil {
+		log.Error().Msgf("Error adding listened track: %v", err)
 	}
 }
 
-// Close the playRequests channel
-func (a *Audio) Close() {
-	close(a.playRequests)
-	close(a.stopChan)
+func (a *Audio) setPaused(paused bool) {
+	if a.playback == nil || a.playback.ctrl.Paused == paused {
+		return
+	}
+
+	a.output.Lock()
+	defer a.output.Unlock()
+
+	a.playback.ctrl.Paused = paused
+	a.playerState.IsPlaying = !paused
+}
+
+// changeVolume changes the volume by the given step, within the configured limits.
+func (a *Audio) changeVolume(step float64) {
+	a.output.Lock()
+	defer a.output.Unlock()
+
+	a.volume.Volume += step
+	a.clampVolume()
+}
+
+// clampVolume keeps the volume within the limits of the settings and the
+// active profile, with the output locked.
+func (a *Audio) clampVolume() {
+	settings := a.currentSettings()
+	if a.volume.Volume > settings.MaxVolume {
+		a.volume.Volume = settings.MaxVolume
+	}
+	if a.volume.Volume < settings.MinVolume {
+		a.volume.Volume = settings.MinVolume
+	}
+	if a.generator != nil {
+		a.generator.volume.Volume = math.Max(settings.MinVolume, math.Min(settings.MaxVolume, a.generator.volume.Volume))
+		a.updateGeneratorState()
+	}
+}
+
+func (a *Audio) logPosition() {
+	if a.playback == nil {
+		return
+	}
+
+	a.output.Lock()
+	position := a.playback.streamer.Position()
+	a.output.Unlock()
+
+	elapsedTime := a.playback.format.SampleRate.D(position).Round(time.Second)
+	log.Info().Msgf("Position: %s", elapsedTime)
 }
@@ -0,0 +1,144 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newStressAudio runs an audio manager on a null output, with short tracks
+// ending while the commands are sent.
+func newStressAudio(t *testing.T) (*Audio, []*Track) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for i := range 3 {
+		writeTrack(t, dir, fmt.Sprintf("track%d.wav", i), 0.5, time.Duration(20*(i+1))*time.Millisecond)
+	}
+
+	config := Config{StoragePath: dir, SampleRate: int(testSampleRate)}
+	a, err := NewAudioWithOutput(config, testSettings, &testCapabilities{}, NewNullOutput(testSampleRate, testBufferSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Run(context.Background())
+
+	return a, a.Tracks()
+}
+
+// hammer calls random methods of the audio manager from several goroutines,
+// until each one made the given number of calls or the audio manager is closed.
+func hammer(t *testing.T, a *Audio, tracks []*Track, goroutines, calls int) *sync.WaitGroup {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(g)))
+
+			for range calls {
+				var err error
+				switch rng.Intn(10) {
+				case 0:
+					err = a.PlayTrack(tracks[rng.Intn(len(tracks))].ID, SourceHTTP)
+				case 1:
+					err = a.PlayRandomTrack(SourceGPIO)
+				case 2:
+					a.Stop(EndStopped)
+				case 3:
+					a.IncreaseVolume()
+				case 4:
+					a.DecreaseVolume()
+				case 5:
+					a.Pause()
+				case 6:
+					a.Resume()
+				case 7:
+					a.Mute(rng.Intn(2) == 0)
+				case 8:
+					err = a.SetLoop(Loop{Mode: []LoopMode{LoopOff, LoopOne, LoopAll}[rng.Intn(3)]})
+				case 9:
+					state := a.GetPlayerState()
+					_ = state.CurrentTrack
+					_ = a.Tracks()
+				}
+
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	return &wg
+}
+
+// waitTimeout waits for the group, failing the test on a deadlock.
+func waitTimeout(t *testing.T, wg *sync.WaitGroup, timeout time.Duration) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("deadlock: the calls did not return")
+	}
+}
+
+func TestAudioConcurrentCommands(t *testing.T) {
+	a, tracks := newStressAudio(t)
+	defer a.Close()
+
+	waitTimeout(t, hammer(t, a, tracks, 16, 200), 30*time.Second)
+
+	// The actor still answers once hammered
+	if err := a.PlayTrack(tracks[0].ID, SourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	a.Stop(EndStopped)
+	if state := a.GetPlayerState(); state.CurrentTrack != nil {
+		t.Fatalf("expected no track once stopped, got %+v", state.CurrentTrack)
+	}
+}
+
+func TestAudioCloseWhileBusy(t *testing.T) {
+	a, tracks := newStressAudio(t)
+
+	wg := hammer(t, a, tracks, 16, 1000)
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		a.Close()
+		close(closed)
+	}()
+	// Concurrent calls to Close all wait for the shutdown
+	a.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock: Close did not return")
+	}
+	waitTimeout(t, wg, 10*time.Second)
+
+	// The audio manager refuses the commands once closed
+	if err := a.PlayTrack(tracks[0].ID, SourceHTTP); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	a.Stop(EndStopped)
+}
@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// timeOfDayLayout is the layout of the bounds of a time window.
+const timeOfDayLayout = "15:04"
+
+var (
+	ErrInvalidAvailability = errors.New("invalid availability")
+	ErrTrackUnavailable    = errors.New("track is unavailable")
+)
+
+// TimeWindow is a daily period in the local time zone, such as 19:00 to 07:00.
+// It ends on the next day when its end is before its start.
+type TimeWindow struct {
+	From string `json:"from"` // From is the start of the window, formatted as 15:04.
+	To   string `json:"to"`   // To is the end of the window, excluded, formatted as 15:04.
+}
+
+// minutes returns the bounds of the window in minutes since midnight.
+func (w TimeWindow) minutes() (from, to int, err error) {
+	start, err := time.Parse(timeOfDayLayout, w.From)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid window start %q, expected 15:04", ErrInvalidAvailability, w.From)
+	}
+	end, err := time.Parse(timeOfDayLayout, w.To)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid window end %q, expected 15:04", ErrInvalidAvailability, w.To)
+	}
+	if start.Equal(end) {
+		return 0, 0, fmt.Errorf("%w: empty window %s-%s", ErrInvalidAvailability, w.From, w.To)
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// Contains tells whether the time of day of t is within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	from, to, err := w.minutes()
+	if err != nil {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if from < to {
+		return minute >= from && minute < to
+	}
+	// The window spans midnight
+	return minute >= from || minute < to
+}
+
+// Availability tells when a track may be played without being explicitly chosen.
+type Availability struct {
+	Hidden          bool         `json:"hidden"`           // Hidden hides the track from the children and the random play.
+	DisabledUntil   *time.Time   `json:"disabled_until"`   // DisabledUntil disables the track until the given time, if set.
+	DisabledWindows []TimeWindow `json:"disabled_windows"` // DisabledWindows disable the track every day during the windows.
+}
+
+// Validate checks the time windows.
+func (a Availability) Validate() error {
+	for _, window := range a.DisabledWindows {
+		if _, _, err := window.minutes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Available tells whether the track may be played at the given time, unless
+// explicitly chosen by a parent.
+func (t *Track) Available(now time.Time) bool {
+	if t.Hidden || (t.DisabledUntil != nil && now.Before(*t.DisabledUntil)) {
+		return false
+	}
+	for _, window := range t.DisabledWindows {
+		if window.Contains(now.Local()) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetAvailability sets when the track may be played and returns the updated track.
+func (a *Audio) SetAvailability(id uuid.UUID, availability Availability) (*Track, error) {
+	if err := availability.Validate(); err != nil {
+		return nil, err
+	}
+
+	reply := make(chan trackResult, 1)
+	if !a.send(availabilityCommand{trackID: id, availability: availability, reply: reply}) {
+		return nil, ErrClosed
+	}
+	result := <-reply
+	return result.track, result.err
+}
+
+// setAvailability saves the availability of the track, then replaces the track.
+func (a *Audio) setAvailability(id uuid.UUID, availability Availability) (*Track, error) {
+	track, ok := a.tracks[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrTrackNotFound, id)
+	}
+
+	if err := a.capabilities.SetTrackAvailability(id, availability); err != nil {
+		return nil, fmt.Errorf("failed to set availability of track %q: %w", track.Name, err)
+	}
+
+	updated := *track
+	updated.Hidden = availability.Hidden
+	updated.DisabledUntil = availability.DisabledUntil
+	updated.DisabledWindows = availability.DisabledWindows
+	a.replaceTrack(&updated)
+
+	return &updated, nil
+}
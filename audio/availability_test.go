@@ -0,0 +1,52 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPlayTrackAvailability(t *testing.T) {
+	dir := t.TempDir()
+	writeTrack(t, dir, "track.wav", 0.5, 10*time.Second)
+
+	a, _, _ := newTestAudio(t, context.Background(), Config{StoragePath: dir}, testSettings)
+	track := trackByName(t, a, "track.wav")
+
+	now := time.Now()
+	until := now.Add(time.Hour)
+	window := TimeWindow{From: now.Add(-time.Hour).Format(timeOfDayLayout), To: now.Add(time.Hour).Format(timeOfDayLayout)}
+	for _, availability := range []Availability{
+		{Hidden: true},
+		{DisabledUntil: &until},
+		{DisabledWindows: []TimeWindow{window}},
+	} {
+		if _, err := a.SetAvailability(track.ID, availability); err != nil {
+			t.Fatal(err)
+		}
+
+		// A card, like the button and the schedule, cannot play the track
+		for _, source := range []Source{SourceCard, SourceGPIO, SourceSchedule} {
+			if err := a.PlayTrack(track.ID, source); !errors.Is(err, ErrTrackUnavailable) {
+				t.Fatalf("%+v: expected ErrTrackUnavailable from %s, got %v", availability, source, err)
+			}
+		}
+		if err := a.PlayRandomTrack(SourceGPIO); err == nil {
+			t.Fatalf("%+v: expected no random track", availability)
+		}
+
+		// A parent still chooses it through the API
+		if err := a.PlayTrack(track.ID, SourceHTTP); err != nil {
+			t.Fatal(err)
+		}
+		a.Stop(EndStopped)
+	}
+
+	if _, err := a.SetAvailability(track.ID, Availability{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.PlayTrack(track.ID, SourceCard); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,215 @@
+package audio
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// command is a request executed by the Run goroutine, which owns the audio state.
+type command interface {
+	execute(a *Audio)
+}
+
+type playCommand struct {
+	trackID uuid.UUID
+	source  Source
+	reply   chan<- error
+}
+
+func (c playCommand) execute(a *Audio) {
+	c.reply <- a.playTrack(c.trackID, c.source)
+}
+
+type playRandomCommand struct {
+	source Source
+	reply  chan<- error
+}
+
+func (c playRandomCommand) execute(a *Audio) {
+	c.reply <- a.playRandomTrack(c.source)
+}
+
+// trackEndedCommand is sent when the speaker reached the end of a playback.
+type trackEndedCommand struct {
+	playbackID int
+}
+
+func (c trackEndedCommand) execute(a *Audio) {
+	// The playback may have been stopped or replaced in the meantime
+	if a.playback == nil || a.playback.id != c.playbackID {
+		return
+	}
+
+	ended := a.playback
+	a.stopPlayback(EndFinished)
+
+	if a.playerState.Loop.Mode != LoopAll {
+		return
+	}
+	if next, ok := a.nextTrack(ended.track); ok {
+		if err := a.playTrack(next.ID, ended.source); err != nil {
+			log.Error().Err(err).Msg("Error playing the next track")
+		}
+	}
+}
+
+// trackWrappedCommand is sent when the looper went back from the end position
+// of a playback to its start position.
+type trackWrappedCommand struct {
+	playbackID int
+	end, start int
+}
+
+func (c trackWrappedCommand) execute(a *Audio) {
+	// The playback may have been stopped or replaced in the meantime
+	if a.playback == nil || a.playback.id != c.playbackID {
+		return
+	}
+
+	// Each repeat of the track is a listen, the repeats of a section are one
+	if a.playerState.Loop.Mode != LoopOne {
+		return
+	}
+
+	current := a.playback
+	a.recordListen(current, EndFinished, c.end)
+	current.startTime = time.Now()
+	current.startPos = c.start
+}
+
+type playerStateCommand struct {
+	reply chan<- PlayerState
+}
+
+func (c playerStateCommand) execute(a *Audio) {
+	c.reply <- a.playerState
+}
+
+type tracksCommand struct {
+	reply chan<- []*Track
+}
+
+func (c tracksCommand) execute(a *Audio) {
+	c.reply <- append(a.sortedTracks(), generatorTracks()...)
+}
+
+type getTrackCommand struct {
+	trackID uuid.UUID
+	reply   chan<- *Track
+}
+
+func (c getTrackCommand) execute(a *Audio) {
+	c.reply <- a.tracks[c.trackID]
+}
+
+type addTrackCommand struct {
+	track *Track
+	reply chan<- struct{}
+}
+
+func (c addTrackCommand) execute(a *Audio) {
+	a.tracks[c.track.ID] = c.track
+	c.reply <- struct{}{}
+}
+
+type removeTrackCommand struct {
+	trackID uuid.UUID
+	reply   chan<- error
+}
+
+func (c removeTrackCommand) execute(a *Audio) {
+	c.reply <- a.removeTrack(c.trackID)
+}
+
+// trackResult is the reply of the commands updating a track.
+type trackResult struct {
+	track *Track
+	err   error
+}
+
+type rateCommand struct {
+	trackID uuid.UUID
+	rating  Rating
+	reply   chan<- trackResult
+}
+
+func (c rateCommand) execute(a *Audio) {
+	track, err := a.rateTrack(c.trackID, c.rating)
+	c.reply <- trackResult{track: track, err: err}
+}
+
+type availabilityCommand struct {
+	trackID      uuid.UUID
+	availability Availability
+	reply        chan<- trackResult
+}
+
+func (c availabilityCommand) execute(a *Audio) {
+	track, err := a.setAvailability(c.trackID, c.availability)
+	c.reply <- trackResult{track: track, err: err}
+}
+
+type toggleFavouriteCommand struct {
+	reply chan<- trackResult
+}
+
+func (c toggleFavouriteCommand) execute(a *Audio) {
+	if a.playback == nil {
+		c.reply <- trackResult{err: fmt.Errorf("%w: nothing is playing", ErrNoTrack)}
+		return
+	}
+
+	track := a.playback.track
+	rating := Rating{Favourite: !track.Favourite, Rating: track.Rating}
+	track, err := a.rateTrack(track.ID, rating)
+	c.reply <- trackResult{track: track, err: err}
+}
+
+// funcCommand runs a function without result, such as a volume or pause change.
+type funcCommand struct {
+	fn    func()
+	reply chan<- struct{}
+}
+
+func (c funcCommand) execute(a *Audio) {
+	c.fn()
+	c.reply <- struct{}{}
+}
+
+type profileCommand struct {
+	reply chan<- *Profile
+}
+
+func (c profileCommand) execute(a *Audio) {
+	c.reply <- a.profile
+}
+
+type canPlayCommand struct {
+	trackID uuid.UUID
+	reply   chan<- error
+}
+
+func (c canPlayCommand) execute(a *Audio) {
+	// The generators only depend on the library of the profile
+	if _, ok := generatorByTrack(c.trackID); ok {
+		for _, track := range generatorTracks() {
+			if track.ID == c.trackID && !a.profile.Allows(track) {
+				c.reply <- fmt.Errorf("%w: %q", ErrTrackUnavailable, track.Name)
+				return
+			}
+		}
+		c.reply <- nil
+		return
+	}
+
+	track, ok := a.tracks[c.trackID]
+	if !ok {
+		c.reply <- fmt.Errorf("%w: %q", ErrTrackNotFound, c.trackID)
+		return
+	}
+	_, err := a.canPlay(track)
+	c.reply <- err
+}
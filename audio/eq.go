@@ -0,0 +1,228 @@
+package audio
+
+import (
+	"math"
+
+	"github.com/gopxl/beep"
+)
+
+const (
+	bassFrequency   = 200.0  // bassFrequency is the corner frequency in Hz of the bass shelf.
+	trebleFrequency = 4000.0 // trebleFrequency is the corner frequency in Hz of the treble shelf.
+	shelfQ          = math.Sqrt2 / 2
+)
+
+// EQ shapes the tone of the playback: bass and treble shelves, parametric
+// bands, balance and mono downmix. Gains are in dB, the zero value is flat.
+type EQ struct {
+	Bass    float64  `json:"bass" env:"AUDIO_EQ_BASS,default=0"`       // Bass is the gain of the low shelf.
+	Treble  float64  `json:"treble" env:"AUDIO_EQ_TREBLE,default=0"`   // Treble is the gain of the high shelf.
+	Bands   []EQBand `json:"bands"`                                    // Bands are the parametric bands, applied after the shelves.
+	Balance float64  `json:"balance" env:"AUDIO_EQ_BALANCE,default=0"` // Balance goes from -1 (left only) to 1 (right only).
+	Mono    bool     `json:"mono" env:"AUDIO_EQ_MONO,default=false"`   // Mono downmixes both channels, for a single speaker.
+}
+
+// EQBand is a peaking filter of the parametric equalizer.
+type EQBand struct {
+	Frequency float64 `json:"frequency"` // Frequency is the centre frequency in Hz.
+	Gain      float64 `json:"gain"`      // Gain is the gain in dB at the centre frequency.
+	Q         float64 `json:"q"`         // Q is the quality factor, higher values being narrower.
+}
+
+// EQPreset is a named equalizer setting.
+type EQPreset struct {
+	Name string `json:"name"`
+	EQ   EQ     `json:"eq"`
+}
+
+// DefaultEQPresets returns the built-in equalizer presets.
+func DefaultEQPresets() []EQPreset {
+	return []EQPreset{
+		{Name: "flat"},
+		{Name: "small speaker", EQ: EQ{
+			Bass:   -6,
+			Treble: 2,
+			Bands:  []EQBand{{Frequency: 250, Gain: -3, Q: 1}},
+		}},
+		{Name: "night", EQ: EQ{
+			Bass:   -4,
+			Treble: -3,
+		}},
+	}
+}
+
+// biquad is a second order filter, in direct form I, of both channels. Its
+// state being the last samples, it can be changed while playing.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     [2]float64
+}
+
+// newBiquad normalises the coefficients of the filter.
+func newBiquad(b0, b1, b2, a0, a1, a2 float64) *biquad {
+	return &biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+// newShelf returns a low or high shelf filter of the given gain, following the
+// audio EQ cookbook of Robert Bristow-Johnson.
+func newShelf(high bool, frequency, gain float64, sampleRate beep.SampleRate) *biquad {
+	A := math.Pow(10, gain/40)
+	w0 := 2 * math.Pi * frequency / float64(sampleRate)
+	cos := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * shelfQ)
+	sqrtAlpha := 2 * math.Sqrt(A) * alpha
+
+	if high {
+		return newBiquad(
+			A*((A+1)+(A-1)*cos+sqrtAlpha),
+			-2*A*((A-1)+(A+1)*cos),
+			A*((A+1)+(A-1)*cos-sqrtAlpha),
+			(A+1)-(A-1)*cos+sqrtAlpha,
+			2*((A-1)-(A+1)*cos),
+			(A+1)-(A-1)*cos-sqrtAlpha,
+		)
+	}
+	return newBiquad(
+		A*((A+1)-(A-1)*cos+sqrtAlpha),
+		2*A*((A-1)-(A+1)*cos),
+		A*((A+1)-(A-1)*cos-sqrtAlpha),
+		(A+1)+(A-1)*cos+sqrtAlpha,
+		-2*((A-1)+(A+1)*cos),
+		(A+1)+(A-1)*cos-sqrtAlpha,
+	)
+}
+
+// newPeaking returns a peaking filter for the band.
+func newPeaking(band EQBand, sampleRate beep.SampleRate) *biquad {
+	A := math.Pow(10, band.Gain/40)
+	w0 := 2 * math.Pi * band.Frequency / float64(sampleRate)
+	cos := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * band.Q)
+
+	return newBiquad(1+alpha*A, -2*cos, 1-alpha*A, 1+alpha/A, -2*cos, 1-alpha/A)
+}
+
+// identity is the coefficients of a filter leaving the sound unchanged.
+var identity = biquad{b0: 1}
+
+// update changes the coefficients of the filter, keeping its state so that
+// the sound is not interrupted.
+func (f *biquad) update(coefficients *biquad) {
+	f.b0, f.b1, f.b2 = coefficients.b0, coefficients.b1, coefficients.b2
+	f.a1, f.a2 = coefficients.a1, coefficients.a2
+}
+
+func (f *biquad) process(channel int, x float64) float64 {
+	y := f.b0*x + f.b1*f.x1[channel] + f.b2*f.x2[channel] - f.a1*f.y1[channel] - f.a2*f.y2[channel]
+	f.x1[channel], f.x2[channel] = x, f.x1[channel]
+	f.y1[channel], f.y2[channel] = y, f.y1[channel]
+	return y
+}
+
+// equalizer applies the EQ to the streamer. Its settings are changed with the
+// output locked, without interrupting the playback.
+type equalizer struct {
+	Streamer beep.Streamer
+
+	eq         EQ
+	trebleCut  float64 // trebleCut is added to the treble gain, by the night mode.
+	sampleRate beep.SampleRate
+	filters    []*biquad // filters are the bass shelf, the treble shelf, then the bands.
+}
+
+// set changes the EQ applied to the playback.
+func (e *equalizer) set(eq EQ) {
+	e.eq = eq
+	e.build()
+}
+
+// setTrebleCut changes the gain added to the treble of the EQ.
+func (e *equalizer) setTrebleCut(gain float64) {
+	if gain != e.trebleCut {
+		e.trebleCut = gain
+		e.build()
+	}
+}
+
+// reset plays the streamer at the sample rate, clearing the state of the filters.
+func (e *equalizer) reset(streamer beep.Streamer, sampleRate beep.SampleRate) {
+	e.Streamer = streamer
+	e.sampleRate = sampleRate
+	e.filters = nil
+	e.build()
+}
+
+// build computes the filters for the EQ and the sample rate. The existing
+// filters keep their state, so that live adjustments do not click. Flat
+// filters, and those above the Nyquist frequency that cannot be applied,
+// leave the sound unchanged.
+func (e *equalizer) build() {
+	if e.sampleRate == 0 {
+		e.filters = nil
+		return
+	}
+
+	nyquist := float64(e.sampleRate) / 2
+	coefficients := []*biquad{&identity, &identity}
+	if e.eq.Bass != 0 {
+		coefficients[0] = newShelf(false, bassFrequency, e.eq.Bass, e.sampleRate)
+	}
+	if treble := e.eq.Treble + e.trebleCut; treble != 0 && trebleFrequency < nyquist {
+		coefficients[1] = newShelf(true, trebleFrequency, treble, e.sampleRate)
+	}
+	for _, band := range e.eq.Bands {
+		if band.Gain != 0 && band.Frequency < nyquist {
+			coefficients = append(coefficients, newPeaking(band, e.sampleRate))
+		} else {
+			coefficients = append(coefficients, &identity)
+		}
+	}
+
+	filters := make([]*biquad, len(coefficients))
+	for i := range coefficients {
+		if i < len(e.filters) {
+			filters[i] = e.filters[i]
+		} else {
+			filters[i] = &biquad{}
+		}
+		filters[i].update(coefficients[i])
+	}
+	e.filters = filters
+}
+
+func (e *equalizer) Stream(samples [][2]float64) (int, bool) {
+	if e.Streamer == nil {
+		return 0, false
+	}
+
+	n, ok := e.Streamer.Stream(samples)
+
+	left, right := 1.0, 1.0
+	if e.eq.Balance > 0 {
+		left -= e.eq.Balance
+	} else {
+		right += e.eq.Balance
+	}
+
+	for i := range samples[:n] {
+		for _, filter := range e.filters {
+			samples[i][0] = filter.process(0, samples[i][0])
+			samples[i][1] = filter.process(1, samples[i][1])
+		}
+		if e.eq.Mono {
+			mono := (samples[i][0] + samples[i][1]) / 2
+			samples[i][0], samples[i][1] = mono, mono
+		}
+		samples[i][0] *= left
+		samples[i][1] *= right
+	}
+
+	return n, ok
+}
+
+func (e *equalizer) Err() error {
+	if e.Streamer == nil {
+		return nil
+	}
+	return e.Streamer.Err()
+}
@@ -0,0 +1,99 @@
+package audio
+
+import (
+	"github.com/gopxl/beep"
+)
+
+// fade linearly lowers the gain of the streamer to silence, then ends it.
+type fade struct {
+	streamer  beep.Streamer
+	total     int
+	remaining int
+}
+
+func newFade(streamer beep.Streamer, samples int) *fade {
+	return &fade{streamer: streamer, total: samples, remaining: samples}
+}
+
+func (f *fade) Stream(samples [][2]float64) (int, bool) {
+	if f.remaining <= 0 {
+		return 0, false
+	}
+	if len(samples) > f.remaining {
+		samples = samples[:f.remaining]
+	}
+
+	n, ok := f.streamer.Stream(samples)
+	for i := range samples[:n] {
+		gain := float64(f.remaining-i) / float64(f.total)
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+	}
+	f.remaining -= n
+
+	return n, ok
+}
+
+func (f *fade) Err() error {
+	return f.streamer.Err()
+}
+
+// fadeIn linearly raises the gain of the streamer from silence, then plays it unchanged.
+type fadeIn struct {
+	streamer beep.Streamer
+	total    int
+	done     int
+}
+
+func newFadeIn(streamer beep.Streamer, samples int) *fadeIn {
+	return &fadeIn{streamer: streamer, total: samples}
+}
+
+func (f *fadeIn) Stream(samples [][2]float64) (int, bool) {
+	n, ok := f.streamer.Stream(samples)
+	for i := range samples[:n] {
+		if f.done >= f.total {
+			break
+		}
+		gain := float64(f.done) / float64(f.total)
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+		f.done++
+	}
+	return n, ok
+}
+
+func (f *fadeIn) Err() error {
+	return f.streamer.Err()
+}
+
+// tail plays the end of a replaced playback, fading out under the next one,
+// then releases its resources. It is streamed and released with the output locked.
+type tail struct {
+	fade     *fade
+	release  func()
+	released bool
+}
+
+func (t *tail) Stream(samples [][2]float64) (int, bool) {
+	if t.released {
+		return 0, false
+	}
+	n, ok := t.fade.Stream(samples)
+	if !ok {
+		t.close()
+	}
+	return n, ok
+}
+
+func (t *tail) Err() error {
+	return t.fade.Err()
+}
+
+// close releases the resources of the playback, once.
+func (t *tail) close() {
+	if !t.released {
+		t.released = true
+		t.release()
+	}
+}
@@ -0,0 +1,374 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/effects"
+	"github.com/rs/zerolog/log"
+)
+
+// GeneratorKind identifies a sound generator.
+type GeneratorKind string
+
+// Sound generators, played under the music.
+const (
+	GeneratorWhite     GeneratorKind = "white"
+	GeneratorPink      GeneratorKind = "pink"
+	GeneratorBrown     GeneratorKind = "brown"
+	GeneratorHeartbeat GeneratorKind = "heartbeat"
+	GeneratorRain      GeneratorKind = "rain"
+	GeneratorOcean     GeneratorKind = "ocean"
+)
+
+const (
+	// GeneratorFormat is the format of the virtual tracks of the generators.
+	GeneratorFormat = "generator"
+	// GeneratorCollection is the collection of the virtual tracks of the generators.
+	GeneratorCollection = "generators"
+
+	generatorFadeOut = 5 * time.Second // generatorFadeOut is the fade-out of a generator at the end of its timer.
+)
+
+var (
+	ErrGeneratorNotFound = errors.New("generator not found")
+	ErrInvalidGenerator  = errors.New("invalid generator options")
+)
+
+// generatorNamespace derives the stable IDs of the virtual tracks of the generators.
+var generatorNamespace = uuid.MustParse("5f0e4b8a-3c1d-4e6f-9a2b-7d8c1e0f4a63")
+
+// generatorNames are the names of the virtual tracks of the generators.
+var generatorNames = map[GeneratorKind]string{
+	GeneratorWhite:     "White noise",
+	GeneratorPink:      "Pink noise",
+	GeneratorBrown:     "Brown noise",
+	GeneratorHeartbeat: "Heartbeat",
+	GeneratorRain:      "Rain",
+	GeneratorOcean:     "Ocean",
+}
+
+// GeneratorSettings are the defaults of the sound generators.
+type GeneratorSettings struct {
+	Volume float64 `json:"volume" env:"AUDIO_GENERATOR_VOLUME,default=-1"` // Volume is the volume of a started generator, on the scale of the music volume.
+	Timer  int     `json:"timer" env:"AUDIO_GENERATOR_TIMER,default=0"`    // Timer stops a started generator after the given number of minutes, 0 for never.
+}
+
+// Generator describes a sound generator and its virtual track.
+type Generator struct {
+	Kind    GeneratorKind `json:"kind"`
+	Name    string        `json:"name"`
+	TrackID uuid.UUID     `json:"track_id"` // TrackID is the ID of the virtual track playing the generator.
+}
+
+// GeneratorState is the state of the playing generator.
+type GeneratorState struct {
+	Kind   GeneratorKind `json:"kind"`
+	Volume float64       `json:"volume"`
+	StopAt *time.Time    `json:"stop_at"` // StopAt is when the generator stops, nil if never.
+}
+
+// GeneratorOptions override the defaults of a started generator.
+type GeneratorOptions struct {
+	Volume *float64 `json:"volume"`
+	Timer  *int     `json:"timer"` // Timer is the number of minutes before the generator stops, 0 for never.
+}
+
+// Generators returns the sound generators, sorted by name.
+func Generators() []Generator {
+	generators := make([]Generator, 0, len(generatorNames))
+	for kind, name := range generatorNames {
+		generators = append(generators, Generator{Kind: kind, Name: name, TrackID: kind.TrackID()})
+	}
+	sort.Slice(generators, func(i, j int) bool {
+		return generators[i].Name < generators[j].Name
+	})
+	return generators
+}
+
+// TrackID returns the stable ID of the virtual track of the generator.
+func (k GeneratorKind) TrackID() uuid.UUID {
+	return uuid.NewSHA1(generatorNamespace, []byte(k))
+}
+
+// generatorTracks returns the virtual tracks of the generators.
+func generatorTracks() []*Track {
+	generators := Generators()
+	tracks := make([]*Track, len(generators))
+	for i, generator := range generators {
+		tracks[i] = &Track{
+			ID:         generator.TrackID,
+			Format:     GeneratorFormat,
+			Name:       generator.Name,
+			Collection: GeneratorCollection,
+		}
+	}
+	return tracks
+}
+
+// generatorByTrack returns the generator of the virtual track, if any.
+func generatorByTrack(id uuid.UUID) (GeneratorKind, bool) {
+	for kind := range generatorNames {
+		if kind.TrackID() == id {
+			return kind, true
+		}
+	}
+	return "", false
+}
+
+// noise is an infinite streamer computing its samples one by one.
+type noise func() [2]float64
+
+func (n noise) Stream(samples [][2]float64) (int, bool) {
+	for i := range samples {
+		samples[i] = n()
+	}
+	return len(samples), true
+}
+
+func (noise) Err() error { return nil }
+
+// newNoise returns the streamer of the generator at the sample rate.
+func newNoise(kind GeneratorKind, sampleRate beep.SampleRate) (beep.Streamer, error) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	white := func() float64 { return rng.Float64()*2 - 1 }
+	rate := float64(sampleRate)
+
+	switch kind {
+	case GeneratorWhite:
+		return noise(func() [2]float64 {
+			return [2]float64{0.3 * white(), 0.3 * white()}
+		}), nil
+
+	case GeneratorPink:
+		left, right := newPink(white), newPink(white)
+		return noise(func() [2]float64 {
+			return [2]float64{left(), right()}
+		}), nil
+
+	case GeneratorBrown:
+		left, right := newBrown(white), newBrown(white)
+		return noise(func() [2]float64 {
+			return [2]float64{left(), right()}
+		}), nil
+
+	case GeneratorHeartbeat:
+		// Two low thumps, "lub" then "dub", at 60 beats per minute
+		var n int
+		period := int(rate)
+		thump := func(t, frequency, amplitude float64) float64 {
+			if t < 0 {
+				return 0
+			}
+			return amplitude * math.Sin(2*math.Pi*frequency*t) * math.Exp(-t/0.04)
+		}
+		return noise(func() [2]float64 {
+			t := float64(n%period) / rate
+			n++
+			value := thump(t, 55, 0.8) + thump(t-0.3, 45, 0.5)
+			return [2]float64{value, value}
+		}), nil
+
+	case GeneratorRain:
+		// Pink noise with drops of various sizes
+		left, right := newPink(white), newPink(white)
+		var drop, decay float64
+		return noise(func() [2]float64 {
+			if rng.Float64() < 20/rate {
+				drop = 0.2 + 0.3*rng.Float64()
+				decay = math.Exp(-1 / (0.002 + 0.01*rng.Float64()) / rate)
+			}
+			drop *= decay
+			value := drop * white()
+			return [2]float64{0.6*left() + value, 0.6*right() + value}
+		}), nil
+
+	case GeneratorOcean:
+		// Brown noise swelling and ebbing like waves of about 8 seconds
+		left, right := newBrown(white), newBrown(white)
+		var n int
+		return noise(func() [2]float64 {
+			phase := 2 * math.Pi * float64(n) / (8 * rate)
+			n++
+			swell := (1 - math.Cos(phase)) / 2
+			wave := 0.15 + 0.85*swell*swell
+			return [2]float64{wave * left(), wave * right()}
+		}), nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrGeneratorNotFound, kind)
+	}
+}
+
+// newPink returns a pink noise source, following the filter of Paul Kellet.
+func newPink(white func() float64) func() float64 {
+	var b0, b1, b2, b3, b4, b5, b6 float64
+	return func() float64 {
+		w := white()
+		b0 = 0.99886*b0 + w*0.0555179
+		b1 = 0.99332*b1 + w*0.0750759
+		b2 = 0.96900*b2 + w*0.1538520
+		b3 = 0.86650*b3 + w*0.3104856
+		b4 = 0.55000*b4 + w*0.5329522
+		b5 = -0.7616*b5 - w*0.0168980
+		pink := b0 + b1 + b2 + b3 + b4 + b5 + b6 + w*0.5362
+		b6 = w * 0.115926
+		return pink * 0.08
+	}
+}
+
+// newBrown returns a brown noise source, integrating the white noise.
+func newBrown(white func() float64) func() float64 {
+	var last float64
+	return func() float64 {
+		last = (last + 0.02*white()) / 1.02
+		return last * 2
+	}
+}
+
+// generatorPlayback holds the resources of the playing generator.
+type generatorPlayback struct {
+	kind   GeneratorKind
+	volume *effects.Volume // volume is the own volume of the generator.
+	ctrl   *beep.Ctrl      // ctrl detaches the generator from the output once stopped.
+	stopAt time.Time       // stopAt is when the generator stops, zero if never.
+	fading bool            // fading tells whether the generator is fading out.
+}
+
+// StartGenerator plays the generator under the music, replacing the playing one.
+func (a *Audio) StartGenerator(kind GeneratorKind, options GeneratorOptions) (*GeneratorState, error) {
+	var state *GeneratorState
+	var err error
+	a.do(func() {
+		if err = a.startGenerator(kind, options); err == nil {
+			state = a.playerState.Generator
+		}
+	})
+	return state, err
+}
+
+// StopGenerator stops the playing generator, if any.
+func (a *Audio) StopGenerator() {
+	a.do(a.stopGenerator)
+}
+
+// SetGeneratorVolume changes the volume of the playing generator, within the
+// limits of the music volume.
+func (a *Audio) SetGeneratorVolume(volume float64) error {
+	var err error
+	a.do(func() {
+		if a.generator == nil {
+			err = fmt.Errorf("%w: none is playing", ErrGeneratorNotFound)
+			return
+		}
+
+		a.output.Lock()
+		defer a.output.Unlock()
+
+		a.generator.volume.Volume = volume
+		a.clampVolume()
+	})
+	return err
+}
+
+// startGenerator plays the generator, the options overriding the settings.
+func (a *Audio) startGenerator(kind GeneratorKind, options GeneratorOptions) error {
+	streamer, err := newNoise(kind, a.sampleRate)
+	if err != nil {
+		return err
+	}
+
+	volume := a.settings.Generator.Volume
+	if options.Volume != nil {
+		volume = *options.Volume
+	}
+	timer := a.settings.Generator.Timer
+	if options.Timer != nil {
+		timer = *options.Timer
+	}
+	if timer < 0 {
+		return fmt.Errorf("%w: negative timer %d", ErrInvalidGenerator, timer)
+	}
+
+	a.stopGenerator()
+
+	current := &generatorPlayback{
+		kind: kind,
+		volume: &effects.Volume{
+			Streamer: streamer,
+			Base:     a.settings.BaseVolume,
+			Volume:   volume,
+			Silent:   a.volume.Silent,
+		},
+	}
+	current.ctrl = &beep.Ctrl{Streamer: current.volume}
+	if timer > 0 {
+		current.stopAt = time.Now().Add(time.Duration(timer) * time.Minute)
+	}
+
+	a.output.Lock()
+	a.generator = current
+	a.clampVolume()
+	a.output.Unlock()
+
+	a.updateGeneratorState()
+	a.output.Play(current.ctrl)
+
+	log.Info().Msgf("Playing generator: %s", kind)
+	return nil
+}
+
+// stopGenerator detaches the playing generator from the output.
+func (a *Audio) stopGenerator() {
+	if a.generator == nil {
+		return
+	}
+
+	a.output.Lock()
+	a.generator.ctrl.Streamer = nil
+	a.output.Unlock()
+
+	log.Info().Msgf("Stopped generator: %s", a.generator.kind)
+	a.generator = nil
+	a.playerState.Generator = nil
+}
+
+// updateGeneratorState reports the playing generator in the player state.
+func (a *Audio) updateGeneratorState() {
+	if a.generator == nil {
+		a.playerState.Generator = nil
+		return
+	}
+
+	state := &GeneratorState{Kind: a.generator.kind, Volume: a.generator.volume.Volume}
+	if !a.generator.stopAt.IsZero() {
+		stopAt := a.generator.stopAt
+		state.StopAt = &stopAt
+	}
+	a.playerState.Generator = state
+}
+
+// checkGeneratorTimer fades the generator out at the end of its timer, then stops it.
+func (a *Audio) checkGeneratorTimer() {
+	current := a.generator
+	if current == nil || current.stopAt.IsZero() {
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case !now.Before(current.stopAt.Add(generatorFadeOut)):
+		a.stopGenerator()
+	case !now.Before(current.stopAt) && !current.fading:
+		a.output.Lock()
+		current.volume.Streamer = newFade(current.volume.Streamer, a.sampleRate.N(generatorFadeOut))
+		current.fading = true
+		a.output.Unlock()
+	}
+}
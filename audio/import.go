@@ -0,0 +1,269 @@
+package audio
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxImportEntries is the maximum number of entries read from an archive.
+const maxImportEntries = 2000
+
+var (
+	ErrUnsupportedArchive = errors.New("unsupported archive, expected .zip or .tar.gz")
+	ErrImportTooLarge     = errors.New("import size limit reached")
+)
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// ImportStatus describes the outcome of an archive entry.
+type ImportStatus string
+
+const (
+	ImportImported ImportStatus = "imported"
+	ImportSkipped  ImportStatus = "skipped"
+	ImportFailed   ImportStatus = "failed"
+)
+
+// ImportEntry is the outcome of a single archive entry.
+type ImportEntry struct {
+	Name   string       `json:"name"`            // Name is the path of the entry in the archive.
+	Status ImportStatus `json:"status"`          // Status tells whether the entry was imported.
+	Track  *Track       `json:"track,omitempty"` // Track is the imported track, if any.
+	Cover  string       `json:"cover,omitempty"` // Cover is the path of the imported cover image, if any.
+	Error  string       `json:"error,omitempty"` // Error explains why the entry was skipped or failed.
+}
+
+// ImportReport lists the outcome of every entry of an imported archive.
+type ImportReport struct {
+	Collection string         `json:"collection"`
+	Imported   int            `json:"imported"`
+	Skipped    int            `json:"skipped"`
+	Failed     int            `json:"failed"`
+	Entries    []*ImportEntry `json:"entries"`
+}
+
+// Add counts the entry and adds it to the report.
+func (r *ImportReport) Add(entry *ImportEntry) {
+	switch entry.Status {
+	case ImportImported:
+		r.Imported++
+	case ImportSkipped:
+		r.Skipped++
+	case ImportFailed:
+		r.Failed++
+	}
+	r.Entries = append(r.Entries, entry)
+}
+
+// importBudget bounds the total number of bytes extracted from an archive.
+type importBudget struct {
+	remaining int64
+}
+
+// reader returns a reader failing with ErrImportTooLarge once the budget is exhausted.
+func (b *importBudget) reader(src io.Reader) io.Reader {
+	return &budgetReader{src: src, budget: b}
+}
+
+type budgetReader struct {
+	src    io.Reader
+	budget *importBudget
+}
+
+func (r *budgetReader) Read(buf []byte) (int, error) {
+	if r.budget.remaining <= 0 {
+		return 0, ErrImportTooLarge
+	}
+	if int64(len(buf)) > r.budget.remaining {
+		buf = buf[:r.budget.remaining]
+	}
+	n, err := r.src.Read(buf)
+	r.budget.remaining -= int64(n)
+	return n, err
+}
+
+// Import extracts the supported tracks and cover images of a .zip or .tar.gz
+// archive into the given collection. Each entry goes through the same
+// validation as a single upload: invalid entries are reported and skipped.
+func (a *Audio) Import(src io.Reader, collection string) (*ImportReport, error) {
+	dir, err := sanitizeCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(src)
+	magic, _ := buffered.Peek(len(zipMagic))
+
+	report := &ImportReport{Collection: filepath.ToSlash(dir)}
+	budget := &importBudget{remaining: a.maxImportSize}
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		err = a.importZip(buffered, dir, budget, report)
+	case bytes.HasPrefix(magic, gzipMagic):
+		err = a.importTarGz(buffered, dir, budget, report)
+	default:
+		err = ErrUnsupportedArchive
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// importZip spools the zip archive to a temporary file, as zip requires random access.
+func (a *Audio) importZip(src io.Reader, dir string, budget *importBudget, report *ImportReport) error {
+	tmpPath, err := a.writeTempFile(src, ".zip", a.maxImportSize)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	archive, err := zip.OpenReader(tmpPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+	}
+	defer archive.Close()
+
+	for idx, file := range archive.File {
+		if idx >= maxImportEntries {
+			report.Add(&ImportEntry{
+				Name:   file.Name,
+				Status: ImportSkipped,
+				Error:  fmt.Sprintf("more than %d entries", maxImportEntries),
+			})
+			continue
+		}
+
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		report.Add(a.importEntry(file.Name, dir, budget, func() (io.ReadCloser, error) {
+			return file.Open()
+		}))
+	}
+
+	return nil
+}
+
+// importTarGz streams the entries of the gzipped tar archive.
+func (a *Audio) importTarGz(src io.Reader, dir string, budget *importBudget, report *ImportReport) error {
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for idx := 0; ; idx++ {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+		}
+
+		if idx >= maxImportEntries {
+			report.Add(&ImportEntry{
+				Name:   header.Name,
+				Status: ImportSkipped,
+				Error:  fmt.Sprintf("more than %d entries", maxImportEntries),
+			})
+			continue
+		}
+
+		// Links are skipped as they could point outside of the storage path
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		report.Add(a.importEntry(header.Name, dir, budget, func() (io.ReadCloser, error) {
+			return io.NopCloser(archive), nil
+		}))
+	}
+}
+
+// importEntry stores a single archive entry in the collection, keeping its sanitized sub-directories.
+func (a *Audio) importEntry(
+	name, dir string,
+	budget *importBudget,
+	open func() (io.ReadCloser, error),
+) *ImportEntry {
+	entry := &ImportEntry{Name: name}
+
+	entryDir, fileName := path.Split(strings.ReplaceAll(name, "\\", "/"))
+	ext := filepath.Ext(fileName)
+
+	isTrack := isSupportedFormat(ext)
+	if !isTrack && !IsCoverFormat(ext) {
+		entry.Status = ImportSkipped
+		entry.Error = fmt.Sprintf("%v: %q", ErrUnsupportedFormat, ext)
+		return entry
+	}
+
+	collection, err := sanitizeCollection(entryDir)
+	if err != nil {
+		entry.Status = ImportSkipped
+		entry.Error = err.Error()
+		return entry
+	}
+	collection = filepath.Join(dir, collection)
+
+	src, err := open()
+	if err != nil {
+		entry.Status = ImportFailed
+		entry.Error = err.Error()
+		return entry
+	}
+	defer src.Close()
+
+	if isTrack {
+		entry.Track, err = a.AddTrack(collection, fileName, budget.reader(src))
+	} else {
+		entry.Cover, err = a.AddCover(collection, fileName, budget.reader(src))
+	}
+
+	switch {
+	case err == nil:
+		entry.Status = ImportImported
+	case errors.Is(err, ErrInvalidFileName), errors.Is(err, ErrImportTooLarge):
+		entry.Status = ImportSkipped
+		entry.Error = err.Error()
+	default:
+		entry.Status = ImportFailed
+		entry.Error = err.Error()
+	}
+
+	return entry
+}
+
+// ImportFile stores a track or a cover image at its path relative to the
+// storage path, such as a file restored from a backup, with the validation of
+// an archive entry. Files already in the storage path are skipped.
+func (a *Audio) ImportFile(name string, src io.Reader) *ImportEntry {
+	fullPath := filepath.Join(a.storagePath, filepath.FromSlash(path.Clean("/"+name)))
+	if _, err := os.Stat(fullPath); err == nil {
+		return &ImportEntry{Name: name, Status: ImportSkipped, Error: "file already exists"}
+	}
+
+	budget := &importBudget{remaining: a.maxImportSize}
+	return a.importEntry(name, "", budget, func() (io.ReadCloser, error) {
+		return io.NopCloser(src), nil
+	})
+}
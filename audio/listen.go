@@ -0,0 +1,39 @@
+package audio
+
+import (
+	"time"
+)
+
+// Source tells what started a playback.
+type Source string
+
+const (
+	SourceGPIO     Source = "gpio"     // SourceGPIO is a press on the button.
+	SourceHTTP     Source = "http"     // SourceHTTP is a request to the API.
+	SourceSchedule Source = "schedule" // SourceSchedule is a scheduled playback.
+	SourceCard     Source = "card"     // SourceCard is a card put on the reader.
+)
+
+// EndReason tells why a playback ended.
+type EndReason string
+
+const (
+	EndFinished EndReason = "finished" // EndFinished is the end of the track.
+	EndSkipped  EndReason = "skipped"  // EndSkipped is another track started before the end.
+	EndStopped  EndReason = "stopped"  // EndStopped is a stop request, or the shutdown of the player.
+	EndTimer    EndReason = "timer"    // EndTimer is the end of the sleep timer.
+	EndLimit    EndReason = "limit"    // EndLimit is the end of the listening quota or schedule of the profile.
+)
+
+// Listen is a playback recorded in the listening history.
+type Listen struct {
+	Track         *Track
+	Profile       *Profile      // Profile is the active profile during the playback, nil if none.
+	Source        Source        // Source tells what started the playback.
+	EndReason     EndReason     // EndReason tells why the playback ended.
+	At            time.Time     // At is when the playback started.
+	During        time.Duration // During is the time spent playing the track, pauses included.
+	StartPosition time.Duration // StartPosition is the position in the track at the start of the playback.
+	EndPosition   time.Duration // EndPosition is the position in the track at the end of the playback.
+	TrackDuration time.Duration // TrackDuration is the duration of the track.
+}
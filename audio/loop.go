@@ -0,0 +1,194 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gopxl/beep"
+	"github.com/rs/zerolog/log"
+)
+
+// LoopMode tells what is played at the end of a track, or of its section.
+type LoopMode string
+
+const (
+	LoopOff     LoopMode = "off"     // LoopOff stops the playback at the end of the track.
+	LoopOne     LoopMode = "one"     // LoopOne repeats the track.
+	LoopAll     LoopMode = "all"     // LoopAll plays the next track of the collection, back to the first one after the last.
+	LoopSection LoopMode = "section" // LoopSection repeats a section of the track, until another track is played.
+)
+
+var ErrInvalidLoop = errors.New("invalid loop")
+
+// Section is the section of the playing track repeated in LoopSection mode.
+type Section struct {
+	Start float64 `json:"start"` // Start is the start of the section in seconds.
+	End   float64 `json:"end"`   // End is the end of the section in seconds, excluded.
+}
+
+// Loop is a loop mode, with its section in LoopSection mode.
+type Loop struct {
+	Mode    LoopMode `json:"mode"`
+	Section *Section `json:"section,omitempty"`
+}
+
+// looper repeats its streamer, or a section of it, seeking back to the start
+// of the section at the exact sample where its end is reached.
+type looper struct {
+	Streamer beep.StreamSeeker
+
+	repeat     bool                 // repeat tells whether the streamer is repeated.
+	start, end int                  // start and end are the bounds of the repeated section in samples, end being 0 for the whole streamer.
+	wrapped    func(end, start int) // wrapped is called with the output locked when the streamer is sought back from end to start.
+	err        error
+}
+
+// set changes the repeated section, with the output locked.
+func (l *looper) set(repeat bool, start, end int) {
+	l.repeat = repeat
+	l.start = start
+	l.end = end
+}
+
+func (l *looper) Stream(samples [][2]float64) (int, bool) {
+	if !l.repeat {
+		return l.Streamer.Stream(samples)
+	}
+
+	filled := 0
+	for filled < len(samples) {
+		chunk := samples[filled:]
+		if l.end > 0 {
+			remaining := l.end - l.Streamer.Position()
+			if remaining <= 0 {
+				if !l.rewind() {
+					return filled, filled > 0
+				}
+				continue
+			}
+			if len(chunk) > remaining {
+				chunk = chunk[:remaining]
+			}
+		}
+
+		n, ok := l.Streamer.Stream(chunk)
+		filled += n
+		if !ok || n < len(chunk) {
+			// An empty section, or stream, is not repeated forever
+			if n == 0 && l.Streamer.Position() == l.start {
+				return filled, filled > 0
+			}
+			if !l.rewind() {
+				return filled, filled > 0
+			}
+		}
+	}
+
+	return filled, true
+}
+
+// rewind seeks the streamer back to the start of the section.
+func (l *looper) rewind() bool {
+	end := l.Streamer.Position()
+	if err := l.Streamer.Seek(l.start); err != nil {
+		l.err = err
+		return false
+	}
+	if l.wrapped != nil {
+		l.wrapped(end, l.start)
+	}
+	return true
+}
+
+func (l *looper) Err() error {
+	if l.err != nil {
+		return l.err
+	}
+	return l.Streamer.Err()
+}
+
+// SetLoop changes the loop mode. The section of the LoopSection mode must be
+// within the playing track.
+func (a *Audio) SetLoop(loop Loop) error {
+	var err error
+	a.do(func() { err = a.setLoop(loop) })
+	return err
+}
+
+// setLoop changes the loop mode and applies it to the playback.
+func (a *Audio) setLoop(loop Loop) error {
+	switch loop.Mode {
+	case LoopOff, LoopOne, LoopAll:
+		loop.Section = nil
+	case LoopSection:
+		if a.playback == nil {
+			return fmt.Errorf("%w: nothing is playing", ErrNoTrack)
+		}
+		if loop.Section == nil {
+			return fmt.Errorf("%w: missing section", ErrInvalidLoop)
+		}
+		duration := a.playback.format.SampleRate.D(a.playback.streamer.Len()).Seconds()
+		if loop.Section.Start < 0 || loop.Section.Start >= loop.Section.End || loop.Section.End > duration {
+			return fmt.Errorf("%w: section %g-%g outside the track of %gs",
+				ErrInvalidLoop, loop.Section.Start, loop.Section.End, duration)
+		}
+	default:
+		return fmt.Errorf("%w: unknown mode %q, expected off, one, all or section", ErrInvalidLoop, loop.Mode)
+	}
+
+	log.Info().Msgf("Loop mode: %s", loop.Mode)
+	a.playerState.Loop = loop
+	a.applyLoop()
+	return nil
+}
+
+// applyLoop applies the loop mode to the looper of the playback, if any.
+func (a *Audio) applyLoop() {
+	if a.playback == nil {
+		return
+	}
+
+	a.output.Lock()
+	defer a.output.Unlock()
+
+	loop := a.playerState.Loop
+	switch loop.Mode {
+	case LoopOne:
+		a.playback.looper.set(true, 0, 0)
+	case LoopSection:
+		sampleRate := a.playback.format.SampleRate
+		a.playback.looper.set(true,
+			sampleRate.N(time.Duration(loop.Section.Start*float64(time.Second))),
+			sampleRate.N(time.Duration(loop.Section.End*float64(time.Second))))
+	default:
+		a.playback.looper.set(false, 0, 0)
+	}
+}
+
+// nextTrack returns the track following the given one in its collection by
+// name, back to the first one after the last, skipping those that cannot be played.
+func (a *Audio) nextTrack(current *Track) (*Track, bool) {
+	var tracks []*Track
+	for _, track := range a.sortedTracks() {
+		if track.Collection == current.Collection {
+			tracks = append(tracks, track)
+		}
+	}
+
+	index := 0
+	for i, track := range tracks {
+		if track.ID == current.ID {
+			index = i
+			break
+		}
+	}
+
+	for i := 1; i <= len(tracks); i++ {
+		track := tracks[(index+i)%len(tracks)]
+		if _, err := a.canPlay(track); err == nil {
+			return track, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,153 @@
+package audio
+
+import (
+	"math"
+	"time"
+
+	"github.com/gopxl/beep"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	compressorAttack  = 10 * time.Millisecond  // compressorAttack is how fast the compressor lowers the gain.
+	compressorRelease = 200 * time.Millisecond // compressorRelease is how fast the compressor restores the gain.
+)
+
+// NightSettings soften the sound at night: the dynamic range is compressed,
+// the volume is capped and the high frequencies may be reduced.
+type NightSettings struct {
+	MaxVolume float64 `json:"max_volume" env:"AUDIO_NIGHT_MAX_VOLUME,default=0"` // MaxVolume is the volume ceiling of the night mode.
+	Threshold float64 `json:"threshold" env:"AUDIO_NIGHT_THRESHOLD,default=-20"` // Threshold is the level in dB above which the sound is compressed.
+	Ratio     float64 `json:"ratio" env:"AUDIO_NIGHT_RATIO,default=4"`           // Ratio is the compression ratio above the threshold.
+	Treble    float64 `json:"treble" env:"AUDIO_NIGHT_TREBLE,default=-6"`        // Treble is added to the treble gain of the equalizer, 0 to keep it.
+	Start     string  `json:"start" env:"AUDIO_NIGHT_START"`                     // Start is when the night mode starts every day, formatted as 15:04, never if empty.
+	End       string  `json:"end" env:"AUDIO_NIGHT_END"`                         // End is when the night mode ends every day, formatted as 15:04.
+}
+
+// scheduled tells whether the time is within the night schedule, if any.
+func (n NightSettings) scheduled(now time.Time) bool {
+	if n.Start == "" || n.End == "" {
+		return false
+	}
+	return TimeWindow{From: n.Start, To: n.End}.Contains(now.Local())
+}
+
+// compressor lowers the gain of the loud sounds above its threshold. The
+// level of both channels is followed together, so that the stereo image is kept.
+type compressor struct {
+	Streamer beep.Streamer
+
+	enabled   bool
+	threshold float64 // threshold is the level in dB above which the sound is compressed.
+	ratio     float64
+	attack    float64 // attack is the smoothing coefficient of a rising level.
+	release   float64 // release is the smoothing coefficient of a falling level.
+	envelope  float64 // envelope is the followed level of the sound.
+}
+
+// set changes the compression, with the output locked.
+func (c *compressor) set(enabled bool, threshold, ratio float64) {
+	c.enabled = enabled
+	c.threshold = threshold
+	c.ratio = ratio
+}
+
+// reset plays the streamer at the sample rate, clearing the followed level.
+func (c *compressor) reset(streamer beep.Streamer, sampleRate beep.SampleRate) {
+	c.Streamer = streamer
+	c.envelope = 0
+	c.attack = math.Exp(-1 / (compressorAttack.Seconds() * float64(sampleRate)))
+	c.release = math.Exp(-1 / (compressorRelease.Seconds() * float64(sampleRate)))
+}
+
+func (c *compressor) Stream(samples [][2]float64) (int, bool) {
+	if c.Streamer == nil {
+		return 0, false
+	}
+
+	n, ok := c.Streamer.Stream(samples)
+	if !c.enabled || c.ratio <= 1 {
+		return n, ok
+	}
+
+	for i := range samples[:n] {
+		level := math.Max(math.Abs(samples[i][0]), math.Abs(samples[i][1]))
+		coefficient := c.release
+		if level > c.envelope {
+			coefficient = c.attack
+		}
+		c.envelope = coefficient*c.envelope + (1-coefficient)*level
+
+		over := 20*math.Log10(c.envelope+1e-9) - c.threshold
+		if over <= 0 {
+			continue
+		}
+		gain := math.Pow(10, -over*(1-1/c.ratio)/20)
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+	}
+
+	return n, ok
+}
+
+func (c *compressor) Err() error {
+	if c.Streamer == nil {
+		return nil
+	}
+	return c.Streamer.Err()
+}
+
+// SetNightMode enables or disables the night mode, until the next start or end
+// of the night schedule.
+func (a *Audio) SetNightMode(enable bool) {
+	a.do(func() { a.setNightMode(enable) })
+}
+
+// ToggleNightMode enables the night mode, or disables it, and tells whether it is enabled.
+func (a *Audio) ToggleNightMode() bool {
+	var enabled bool
+	a.do(func() {
+		a.setNightMode(!a.nightMode)
+		enabled = a.nightMode
+	})
+	return enabled
+}
+
+// setNightMode applies the night mode to the playback.
+func (a *Audio) setNightMode(enable bool) {
+	if enable != a.nightMode {
+		log.Info().Msgf("Night mode enabled: %t", enable)
+	}
+	a.nightMode = enable
+	a.playerState.NightMode = enable
+
+	a.output.Lock()
+	defer a.output.Unlock()
+
+	a.applyNightMode()
+}
+
+// applyNightMode applies the compressor, the treble cut and the volume ceiling
+// of the night mode to the playback, with the output locked.
+func (a *Audio) applyNightMode() {
+	night := a.settings.Night
+	a.compressor.set(a.nightMode, night.Threshold, night.Ratio)
+
+	trebleCut := 0.0
+	if a.nightMode {
+		trebleCut = night.Treble
+	}
+	a.equalizer.setTrebleCut(trebleCut)
+
+	a.clampVolume()
+}
+
+// checkNightSchedule switches the night mode on at the start of the night
+// schedule and off at its end.
+func (a *Audio) checkNightSchedule() {
+	scheduled := a.settings.Night.scheduled(time.Now())
+	if scheduled != a.nightScheduled {
+		a.nightScheduled = scheduled
+		a.setNightMode(scheduled)
+	}
+}
@@ -0,0 +1,284 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/speaker"
+)
+
+// Output is where the audio manager plays its streamers.
+// Lock and Unlock guard the playing streamers while they are modified.
+type Output interface {
+	Play(s beep.Streamer)
+	Clear()
+	Lock()
+	Unlock()
+	Close() error
+}
+
+// Output kinds selectable with the AUDIO_OUTPUT variable.
+const (
+	OutputSpeaker = "speaker"
+	OutputNull    = "null"
+	OutputFile    = "file"
+)
+
+// outputLatency is the duration of the buffers sent to the output.
+const outputLatency = time.Second / 5
+
+// NewOutput creates the output selected in the configuration.
+func NewOutput(config Config) (Output, error) {
+	sampleRate := beep.SampleRate(config.SampleRate)
+	bufferSize := sampleRate.N(outputLatency)
+
+	switch config.Output {
+	case OutputSpeaker, "":
+		return NewSpeakerOutput(sampleRate, bufferSize)
+	case OutputNull:
+		return NewNullOutput(sampleRate, bufferSize), nil
+	case OutputFile:
+		return NewFileOutput(config.OutputFile, sampleRate, bufferSize)
+	default:
+		return nil, fmt.Errorf("unsupported audio output %q, expected speaker, null or file", config.Output)
+	}
+}
+
+// speakerOutput plays on the sound card through the beep speaker.
+type speakerOutput struct{}
+
+// NewSpeakerOutput initialises the speaker at the given sample rate.
+func NewSpeakerOutput(sampleRate beep.SampleRate, bufferSize int) (Output, error) {
+	if err := speaker.Init(sampleRate, bufferSize); err != nil {
+		return nil, fmt.Errorf("speaker issue : %v", err)
+	}
+	return speakerOutput{}, nil
+}
+
+func (speakerOutput) Play(s beep.Streamer) { speaker.Play(s) }
+func (speakerOutput) Clear()               { speaker.Clear() }
+func (speakerOutput) Lock()                { speaker.Lock() }
+func (speakerOutput) Unlock()              { speaker.Unlock() }
+
+func (speakerOutput) Close() error {
+	speaker.Close()
+	return nil
+}
+
+// sink is an output consuming the mixed samples at the pace of a sound card,
+// so that the playback behaves as on the speaker.
+type sink struct {
+	mu    sync.Mutex
+	mixer beep.Mixer
+	write func(samples [][2]float64) error
+
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func newSink(sampleRate beep.SampleRate, bufferSize int, write func(samples [][2]float64) error) *sink {
+	s := &sink{
+		write: write,
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.run(sampleRate.D(bufferSize), bufferSize)
+	return s
+}
+
+func (s *sink) run(period time.Duration, bufferSize int) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	samples := make([][2]float64, bufferSize)
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.mixer.Stream(samples)
+			s.mu.Unlock()
+
+			if err := s.write(samples); err != nil {
+				s.err = err
+				return
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *sink) Play(streamer beep.Streamer) {
+	s.mu.Lock()
+	s.mixer.Add(streamer)
+	s.mu.Unlock()
+}
+
+func (s *sink) Clear() {
+	s.mu.Lock()
+	s.mixer.Clear()
+	s.mu.Unlock()
+}
+
+func (s *sink) Lock()   { s.mu.Lock() }
+func (s *sink) Unlock() { s.mu.Unlock() }
+
+// stop stops consuming samples and returns the first write error, if any.
+func (s *sink) stop() error {
+	s.closeOnce.Do(func() {
+		close(s.quit)
+	})
+	<-s.done
+	return s.err
+}
+
+// nullOutput discards the played samples.
+type nullOutput struct {
+	*sink
+}
+
+// NewNullOutput creates an output discarding the samples, for machines without sound card.
+func NewNullOutput(sampleRate beep.SampleRate, bufferSize int) Output {
+	return nullOutput{newSink(sampleRate, bufferSize, func([][2]float64) error {
+		return nil
+	})}
+}
+
+func (o nullOutput) Close() error {
+	return o.stop()
+}
+
+// MemoryOutput keeps the played samples in memory.
+type MemoryOutput struct {
+	*sink
+
+	samplesMutex sync.Mutex
+	samples      [][2]float64
+}
+
+// NewMemoryOutput creates an output recording the samples in memory.
+func NewMemoryOutput(sampleRate beep.SampleRate, bufferSize int) *MemoryOutput {
+	output := &MemoryOutput{}
+	output.sink = newSink(sampleRate, bufferSize, func(samples [][2]float64) error {
+		output.samplesMutex.Lock()
+		output.samples = append(output.samples, samples...)
+		output.samplesMutex.Unlock()
+		return nil
+	})
+	return output
+}
+
+// Samples returns a copy of the samples played so far.
+func (o *MemoryOutput) Samples() [][2]float64 {
+	o.samplesMutex.Lock()
+	defer o.samplesMutex.Unlock()
+
+	return append([][2]float64(nil), o.samples...)
+}
+
+func (o *MemoryOutput) Close() error {
+	return o.stop()
+}
+
+// wavHeaderSize is the size of the header written by the file output.
+const wavHeaderSize = 44
+
+// maxWavDataSize is the largest size of the samples of a WAV file, whose
+// sizes are 32-bit, in whole stereo 16-bit frames: about 6.7 hours at 44.1 kHz.
+const maxWavDataSize = (math.MaxUint32 - (wavHeaderSize - 8)) / 4 * 4
+
+// ErrRecordingTooLong is returned by the file output once its WAV file is full.
+var ErrRecordingTooLong = errors.New("audio output file is full")
+
+// fileOutput records the played samples in a 16-bit stereo WAV file.
+type fileOutput struct {
+	*sink
+
+	file       *os.File
+	dataSize   uint32
+	sampleRate beep.SampleRate
+}
+
+// NewFileOutput creates an output recording the samples in the WAV file at the given path.
+// The file is written as the samples are played and finalised on Close.
+func NewFileOutput(path string, sampleRate beep.SampleRate, bufferSize int) (Output, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create audio output file: %w", err)
+	}
+
+	output := &fileOutput{file: file, sampleRate: sampleRate}
+	if err := output.writeHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(wavHeaderSize, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	buf := make([]byte, bufferSize*4)
+	output.sink = newSink(sampleRate, bufferSize, func(samples [][2]float64) error {
+		// The recording stops before its sizes overflow the header
+		if uint64(output.dataSize)+uint64(len(samples)*4) > maxWavDataSize {
+			return fmt.Errorf("%w: more than %d bytes of samples", ErrRecordingTooLong, uint64(maxWavDataSize))
+		}
+		for i, sample := range samples {
+			for channel := range sample {
+				value := int16(math.Round(math.Max(-1, math.Min(1, sample[channel])) * math.MaxInt16))
+				binary.LittleEndian.PutUint16(buf[i*4+channel*2:], uint16(value))
+			}
+		}
+		n, err := file.Write(buf[:len(samples)*4])
+		output.dataSize += uint32(n)
+		return err
+	})
+
+	return output, nil
+}
+
+// writeHeader writes the WAV header matching the samples written so far.
+func (o *fileOutput) writeHeader() error {
+	const numChannels, bytesPerSample = 2, 2
+
+	header := make([]byte, wavHeaderSize)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], wavHeaderSize-8+o.dataSize)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], numChannels)
+	binary.LittleEndian.PutUint32(header[24:], uint32(o.sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(o.sampleRate)*numChannels*bytesPerSample)
+	binary.LittleEndian.PutUint16(header[32:], numChannels*bytesPerSample)
+	binary.LittleEndian.PutUint16(header[34:], bytesPerSample*8)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], o.dataSize)
+
+	if _, err := o.file.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("unable to write audio output header: %w", err)
+	}
+	return nil
+}
+
+func (o *fileOutput) Close() error {
+	err := o.stop()
+	if errHeader := o.writeHeader(); err == nil {
+		err = errHeader
+	}
+	if errClose := o.file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
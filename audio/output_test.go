@@ -0,0 +1,466 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/wav"
+)
+
+const (
+	testSampleRate = beep.SampleRate(44100)
+	testBufferSize = 441   // testBufferSize is a 10 ms output buffer.
+	testTolerance  = 0.001 // testTolerance covers the 16-bit quantisation of the WAV tracks.
+)
+
+// testSettings play the tracks at their own level, with a volume step
+// halving or doubling it.
+var testSettings = Settings{
+	BaseVolume: 2,
+	MinVolume:  -3,
+	MaxVolume:  0,
+	VolumeStep: 1,
+}
+
+// testCapabilities registers the tracks and records the listens in memory.
+type testCapabilities struct {
+	mu      sync.Mutex
+	listens []*Listen
+}
+
+func (c *testCapabilities) RegisterTrack(track *Track) error {
+	track.ID = uuid.New()
+	return nil
+}
+
+func (c *testCapabilities) AddListenedTrack(listen *Listen) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listens = append(c.listens, listen)
+	return nil
+}
+
+func (c *testCapabilities) RateTrack(uuid.UUID, Rating) error { return nil }
+
+func (c *testCapabilities) SetTrackAvailability(uuid.UUID, Availability) error { return nil }
+
+func (c *testCapabilities) Profiles() ([]*Profile, error) { return nil, nil }
+
+func (c *testCapabilities) ActiveProfile() (*Profile, error) { return nil, nil }
+
+func (c *testCapabilities) ActivateProfile(uuid.UUID) (*Profile, error) { return nil, nil }
+
+func (c *testCapabilities) ListeningTime(uuid.UUID, time.Time) (time.Duration, error) { return 0, nil }
+
+// Listens returns a copy of the recorded listens.
+func (c *testCapabilities) Listens() []*Listen {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Listen(nil), c.listens...)
+}
+
+// writeTrack writes a WAV track of constant samples in the directory, and
+// returns the level of its samples once decoded.
+func writeTrack(t *testing.T, dir, name string, value float64, duration time.Duration) float64 {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	remaining := testSampleRate.N(duration)
+	streamer := beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		if remaining == 0 {
+			return 0, false
+		}
+		n := min(len(samples), remaining)
+		for i := range samples[:n] {
+			samples[i] = [2]float64{value, value}
+		}
+		remaining -= n
+		return n, true
+	})
+	format := beep.Format{SampleRate: testSampleRate, NumChannels: 2, Precision: 2}
+	if err := wav.Encode(file, streamer, format); err != nil {
+		t.Fatal(err)
+	}
+
+	// The decoder of beep does not give back the encoded values
+	decoded, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoded.Close()
+	decoder, _, err := wav.Decode(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := make([][2]float64, 1)
+	if n, _ := decoder.Stream(sample); n != 1 {
+		t.Fatalf("failed to decode %s", name)
+	}
+	return sample[0][0]
+}
+
+// newTestAudio runs an audio manager on a memory output, with the tracks of
+// the storage path of the configuration, until the end of the test.
+func newTestAudio(t *testing.T, ctx context.Context, config Config, settings Settings) (*Audio, *MemoryOutput, *testCapabilities) {
+	t.Helper()
+
+	config.SampleRate = int(testSampleRate)
+	capabilities := &testCapabilities{}
+	output := NewMemoryOutput(testSampleRate, testBufferSize)
+	a, err := NewAudioWithOutput(config, settings, capabilities, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Run(ctx)
+	t.Cleanup(a.Close)
+
+	return a, output, capabilities
+}
+
+// trackByName returns the track of the given name.
+func trackByName(t *testing.T, a *Audio, name string) *Track {
+	t.Helper()
+
+	for _, track := range a.Tracks() {
+		if track.Name == name {
+			return track
+		}
+	}
+	t.Fatalf("track %q not found", name)
+	return nil
+}
+
+// waitForLevel waits for the output to play the level.
+func waitForLevel(t *testing.T, output *MemoryOutput, level float64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		samples := output.Samples()
+		if len(samples) > 0 && math.Abs(samples[len(samples)-1][0]-level) < testTolerance {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the output never played %g", level)
+}
+
+// waitForSamples waits until the output played the given number of samples.
+func waitForSamples(t *testing.T, output *MemoryOutput, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(output.Samples()) >= count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the output never played %d samples", count)
+}
+
+// atLevel tells whether the value is one of the levels.
+func atLevel(value float64, levels ...float64) bool {
+	for _, level := range levels {
+		if math.Abs(value-level) < testTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// levelCount returns the number of samples played at the level.
+func levelCount(samples [][2]float64, level float64) int {
+	count := 0
+	for _, sample := range samples {
+		if atLevel(sample[0], level) {
+			count++
+		}
+	}
+	return count
+}
+
+// checkRamp checks that the samples go down from start to end steadily
+// during the given number of samples, up to a buffer.
+func checkRamp(t *testing.T, samples [][2]float64, start, end float64, length int) {
+	t.Helper()
+
+	first, last := -1, -1
+	for i, sample := range samples {
+		if math.Abs(sample[0]-start) < testTolerance {
+			first = i
+		}
+		if first >= 0 && last < 0 && math.Abs(sample[0]-end) < testTolerance {
+			last = i
+		}
+	}
+	if first < 0 || last < 0 {
+		t.Fatalf("no ramp from %g to %g", start, end)
+	}
+
+	for i := first + 1; i <= last; i++ {
+		if samples[i][0] > samples[i-1][0]+testTolerance {
+			t.Fatalf("the ramp goes up at sample %d: %g after %g", i-first, samples[i][0], samples[i-1][0])
+		}
+		if samples[i][0] != samples[i][1] {
+			t.Fatalf("the channels differ at sample %d", i-first)
+		}
+	}
+	if ramp := last - first; ramp < length-testBufferSize || ramp > length+testBufferSize {
+		t.Fatalf("the ramp lasts %d samples, expected %d", ramp, length)
+	}
+}
+
+func TestPlaybackVolume(t *testing.T) {
+	dir := t.TempDir()
+	level := writeTrack(t, dir, "track.wav", 0.5, 10*time.Second)
+
+	a, output, _ := newTestAudio(t, context.Background(), Config{StoragePath: dir}, testSettings)
+	if err := a.PlayTrack(trackByName(t, a, "track.wav").ID, SourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	waitForLevel(t, output, level)
+
+	a.DecreaseVolume()
+	waitForLevel(t, output, level/2)
+
+	// The volume cannot exceed the maximum volume
+	a.IncreaseVolume()
+	a.IncreaseVolume()
+	waitForLevel(t, output, level)
+
+	a.Mute(true)
+	waitForLevel(t, output, 0)
+
+	// The volume changes from one sample to the next
+	for i, sample := range output.Samples() {
+		if !atLevel(sample[0], 0, level/2, level) {
+			t.Fatalf("unexpected sample %d: %g", i, sample[0])
+		}
+	}
+}
+
+func TestPlaybackNextTrack(t *testing.T) {
+	dir := t.TempDir()
+	first := writeTrack(t, dir, "a.wav", 0.5, 300*time.Millisecond)
+	next := writeTrack(t, dir, "b.wav", -0.5, 10*time.Second)
+
+	a, output, capabilities := newTestAudio(t, context.Background(), Config{StoragePath: dir}, testSettings)
+	if err := a.SetLoop(Loop{Mode: LoopAll}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.PlayTrack(trackByName(t, a, "a.wav").ID, SourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	waitForLevel(t, output, next)
+
+	// The first track is played entirely before the next one
+	samples := output.Samples()
+	if count := levelCount(samples, first); count != testSampleRate.N(300*time.Millisecond) {
+		t.Fatalf("%d samples of the first track played, expected %d", count, testSampleRate.N(300*time.Millisecond))
+	}
+
+	listens := capabilities.Listens()
+	if len(listens) != 1 || listens[0].Track.Name != "a.wav" || listens[0].EndReason != EndFinished {
+		t.Fatalf("expected the first track to be listened entirely, got %+v", listens)
+	}
+}
+
+func TestPlaybackFadeOut(t *testing.T) {
+	dir := t.TempDir()
+	level := writeTrack(t, dir, "track.wav", 0.5, 10*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := Config{StoragePath: dir, FadeOutDuration: 300 * time.Millisecond}
+	a, output, capabilities := newTestAudio(t, ctx, config, testSettings)
+	if err := a.PlayTrack(trackByName(t, a, "track.wav").ID, SourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	waitForLevel(t, output, level)
+
+	cancel()
+	<-a.Done()
+
+	checkRamp(t, output.Samples(), level, 0, testSampleRate.N(config.FadeOutDuration))
+
+	listens := capabilities.Listens()
+	if len(listens) != 1 || listens[0].EndReason != EndStopped {
+		t.Fatalf("expected the track to be listened until stopped, got %+v", listens)
+	}
+}
+
+func TestPlaybackCrossfade(t *testing.T) {
+	dir := t.TempDir()
+	first := writeTrack(t, dir, "a.wav", 0.5, 10*time.Second)
+	next := writeTrack(t, dir, "b.wav", -0.5, 10*time.Second)
+
+	config := Config{StoragePath: dir, Crossfade: 300 * time.Millisecond}
+	a, output, capabilities := newTestAudio(t, context.Background(), config, testSettings)
+	if err := a.PlayTrack(trackByName(t, a, "a.wav").ID, SourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	waitForLevel(t, output, first)
+
+	if err := a.PlayTrack(trackByName(t, a, "b.wav").ID, SourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	waitForLevel(t, output, next)
+
+	// The first track fades out while the second one fades in, without silence
+	checkRamp(t, output.Samples(), first, next, testSampleRate.N(config.Crossfade))
+
+	listens := capabilities.Listens()
+	if len(listens) != 1 || listens[0].Track.Name != "a.wav" || listens[0].EndReason != EndSkipped {
+		t.Fatalf("expected the first track to be skipped, got %+v", listens)
+	}
+}
+
+func TestPlaybackCrossfadeCorruptTrack(t *testing.T) {
+	dir := t.TempDir()
+	first := writeTrack(t, dir, "a.wav", 0.5, 10*time.Second)
+	next := writeTrack(t, dir, "b.wav", -0.5, 10*time.Second)
+
+	config := Config{StoragePath: dir, Crossfade: 300 * time.Millisecond}
+	a, output, capabilities := newTestAudio(t, context.Background(), config, testSettings)
+	if err := a.PlayTrack(trackByName(t, a, "a.wav").ID, SourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	waitForLevel(t, output, first)
+
+	// The second track can no longer be decoded
+	corrupt := trackByName(t, a, "b.wav")
+	content, err := os.ReadFile(corrupt.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(corrupt.Path, []byte("not a wav file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.PlayTrack(corrupt.ID, SourceHTTP); err == nil {
+		t.Fatal("expected an error playing a corrupt track")
+	}
+
+	// The first track keeps playing, neither faded out nor recorded
+	played := len(output.Samples())
+	waitForSamples(t, output, played+testSampleRate.N(500*time.Millisecond))
+	for i, sample := range output.Samples()[played:] {
+		if math.Abs(sample[0]-first) > testTolerance {
+			t.Fatalf("unexpected sample %d after the failure: %g", i, sample[0])
+		}
+	}
+	if state := a.GetPlayerState(); state.CurrentTrack == nil || state.CurrentTrack.Name != "a.wav" {
+		t.Fatalf("expected the first track to keep playing, got %+v", state.CurrentTrack)
+	}
+	if listens := capabilities.Listens(); len(listens) != 0 {
+		t.Fatalf("expected no listen, got %+v", listens)
+	}
+
+	// The crossfade still works once the track is fixed
+	if err := os.WriteFile(corrupt.Path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.PlayTrack(corrupt.ID, SourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	waitForLevel(t, output, next)
+	checkRamp(t, output.Samples(), first, next, testSampleRate.N(config.Crossfade))
+}
+
+func TestFileOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.wav")
+	output, err := NewFileOutput(path, testSampleRate, testBufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	played := testSampleRate.N(200 * time.Millisecond)
+	done := make(chan struct{})
+	remaining := played
+	output.Play(beep.Seq(beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		if remaining == 0 {
+			return 0, false
+		}
+		n := min(len(samples), remaining)
+		for i := range samples[:n] {
+			samples[i] = [2]float64{0.5, -0.5}
+		}
+		remaining -= n
+		return n, true
+	}), beep.Callback(func() { close(done) })))
+
+	<-done
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < wavHeaderSize || string(data[0:4]) != "RIFF" || string(data[36:40]) != "data" {
+		t.Fatal("invalid WAV header")
+	}
+	if size := binary.LittleEndian.Uint32(data[40:]); int(size) != len(data)-wavHeaderSize {
+		t.Fatalf("the header holds %d bytes of samples, the file %d", size, len(data)-wavHeaderSize)
+	}
+
+	// The recording holds exactly the played samples, with silence around
+	recorded := 0
+	for frame := data[wavHeaderSize:]; len(frame) >= 4; frame = frame[4:] {
+		left, right := int16(binary.LittleEndian.Uint16(frame)), int16(binary.LittleEndian.Uint16(frame[2:]))
+		switch {
+		case left == 16384 && right == -16384:
+			recorded++
+		case left != 0 || right != 0:
+			t.Fatalf("unexpected frame %d %d", left, right)
+		}
+	}
+	if recorded != played {
+		t.Fatalf("%d samples recorded, expected %d", recorded, played)
+	}
+}
+
+func TestFileOutputFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.wav")
+	// The first write happens after a whole second
+	output, err := NewFileOutput(path, testSampleRate, int(testSampleRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The recording is almost 6.7 hours long
+	file := output.(*fileOutput)
+	file.Lock()
+	file.dataSize = maxWavDataSize - 4
+	file.Unlock()
+
+	// The output stops at the first write
+	select {
+	case <-file.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the output kept recording")
+	}
+
+	if err := output.Close(); !errors.Is(err, ErrRecordingTooLong) {
+		t.Fatalf("expected a full recording, got %v", err)
+	}
+	if file.dataSize != maxWavDataSize-4 {
+		t.Fatalf("%d bytes of samples recorded, expected %d", file.dataSize, maxWavDataSize-4)
+	}
+}
@@ -6,9 +6,13 @@ import (
 
 // PlayerState represents the current state of an audio track playback.
 type PlayerState struct {
-	CurrentTrack *Track `json:"currentTrack"` // CurrentTrack is the audio track currently being played.
-	IsPlaying    bool   `json:"isPlaying"`    // IsPlaying indicates whether the track playback is active.
-	IsMuted      bool   `json:"isMuted"`      // IsMuted indicates whether the sound is muted.
+	CurrentTrack *Track          `json:"currentTrack"` // CurrentTrack is the audio track currently being played.
+	IsPlaying    bool            `json:"isPlaying"`    // IsPlaying indicates whether the track playback is active.
+	IsMuted      bool            `json:"isMuted"`      // IsMuted indicates whether the sound is muted.
+	Profile      *Profile        `json:"profile"`      // Profile is the active profile, nil if none.
+	NightMode    bool            `json:"nightMode"`    // NightMode indicates whether the night mode is enabled.
+	Generator    *GeneratorState `json:"generator"`    // Generator is the sound generator played under the music, nil if none.
+	Loop         Loop            `json:"loop"`         // Loop is the loop mode of the playback.
 }
 
 // InitializeTrack initializes the current track and resets the elapsed and total time.
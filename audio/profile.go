@@ -0,0 +1,244 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidProfile  = errors.New("invalid profile")
+	ErrProfileNotFound = errors.New("profile not found")
+	ErrOutsideSchedule = errors.New("outside the schedule of the profile")
+	ErrQuotaReached    = errors.New("daily listening quota reached")
+)
+
+// Profile restricts the library and the listening time of a child.
+type Profile struct {
+	ID          uuid.UUID       `json:"id"`
+	Name        string          `json:"name"`
+	Collections []string        `json:"collections"` // Collections are the collections, with their sub-collections, the child may listen to, all if empty.
+	DailyQuota  int             `json:"daily_quota"` // DailyQuota is the listening time allowed per day in minutes, 0 for no limit.
+	Schedule    []TimeWindow    `json:"schedule"`    // Schedule is when the child may listen to music, always if empty.
+	Settings    ProfileSettings `json:"settings"`    // Settings override the audio settings while the profile is active.
+}
+
+// ProfileSettings override the audio settings, unset values keeping the settings ones.
+type ProfileSettings struct {
+	MaxVolume      *float64 `json:"max_volume,omitempty"` // MaxVolume is the volume ceiling, never above the settings one.
+	FavouritesOnly *bool    `json:"favourites_only,omitempty"`
+	WeightedRandom *bool    `json:"weighted_random,omitempty"`
+}
+
+// Validate checks the name, the quota and the time windows of the schedule.
+func (p *Profile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidProfile)
+	}
+	if p.DailyQuota < 0 {
+		return fmt.Errorf("%w: negative daily quota", ErrInvalidProfile)
+	}
+	for _, window := range p.Schedule {
+		if _, _, err := window.minutes(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidProfile, err)
+		}
+	}
+	return nil
+}
+
+// Allows tells whether the track belongs to the library of the profile.
+func (p *Profile) Allows(track *Track) bool {
+	if p == nil || len(p.Collections) == 0 {
+		return true
+	}
+	for _, collection := range p.Collections {
+		if inCollection(track, collection) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduled tells whether the child may listen to music at the given time.
+func (p *Profile) scheduled(now time.Time) bool {
+	if p == nil || len(p.Schedule) == 0 {
+		return true
+	}
+	for _, window := range p.Schedule {
+		if window.Contains(now.Local()) {
+			return true
+		}
+	}
+	return false
+}
+
+// profileID returns the ID of the profile, uuid.Nil if none.
+func profileID(profile *Profile) uuid.UUID {
+	if profile == nil {
+		return uuid.Nil
+	}
+	return profile.ID
+}
+
+// apply returns the settings overridden by the profile.
+func (p *Profile) apply(settings Settings) Settings {
+	if p == nil {
+		return settings
+	}
+	if p.Settings.MaxVolume != nil && *p.Settings.MaxVolume < settings.MaxVolume {
+		settings.MaxVolume = *p.Settings.MaxVolume
+	}
+	if p.Settings.FavouritesOnly != nil {
+		settings.FavouritesOnly = *p.Settings.FavouritesOnly
+	}
+	if p.Settings.WeightedRandom != nil {
+		settings.WeightedRandom = *p.Settings.WeightedRandom
+	}
+	return settings
+}
+
+// Profile returns the active profile, nil if none.
+func (a *Audio) Profile() *Profile {
+	reply := make(chan *Profile, 1)
+	if !a.send(profileCommand{reply: reply}) {
+		return nil
+	}
+	return <-reply
+}
+
+// ActivateProfile makes the given profile the active one, or deactivates the
+// profiles for uuid.Nil. The current playback is stopped if the profile changes.
+func (a *Audio) ActivateProfile(id uuid.UUID) (*Profile, error) {
+	profile, err := a.capabilities.ActivateProfile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	a.do(func() { a.setProfile(profile) })
+	return profile, nil
+}
+
+// NextProfile activates the profile following the active one by name, or
+// the first one if none is active.
+func (a *Audio) NextProfile() (*Profile, error) {
+	profiles, err := a.capabilities.Profiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("%w: no profile", ErrProfileNotFound)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	next := profiles[0]
+	if current := a.Profile(); current != nil {
+		for i, profile := range profiles {
+			if profile.ID == current.ID {
+				next = profiles[(i+1)%len(profiles)]
+				break
+			}
+		}
+	}
+
+	return a.ActivateProfile(next.ID)
+}
+
+// RefreshProfile applies the changes of the active profile, if it is the given one.
+func (a *Audio) RefreshProfile(profile *Profile) {
+	a.do(func() {
+		if profileID(a.profile) == profile.ID {
+			a.setProfile(profile)
+		}
+	})
+}
+
+// CanPlay tells whether the track may be played under the restrictions of
+// the active profile, which the parents bypass.
+func (a *Audio) CanPlay(id uuid.UUID) error {
+	reply := make(chan error, 1)
+	if !a.send(canPlayCommand{trackID: id, reply: reply}) {
+		return ErrClosed
+	}
+	return <-reply
+}
+
+// setProfile activates the profile, stopping the playback of the previous one.
+func (a *Audio) setProfile(profile *Profile) {
+	if profileID(a.profile) != profileID(profile) {
+		a.stopPlayback(EndStopped)
+	}
+	a.profile = profile
+	a.playerState.Profile = profile
+	a.applyProfileSettings()
+}
+
+// applyProfileSettings brings the volume back within the limits of the active profile.
+func (a *Audio) applyProfileSettings() {
+	a.output.Lock()
+	defer a.output.Unlock()
+
+	a.clampVolume()
+}
+
+// currentSettings returns the settings overridden by the active profile and
+// the night mode.
+func (a *Audio) currentSettings() Settings {
+	settings := a.profile.apply(a.settings)
+	if a.nightMode && a.settings.Night.MaxVolume < settings.MaxVolume {
+		settings.MaxVolume = a.settings.Night.MaxVolume
+	}
+	return settings
+}
+
+// canPlay checks the availability of the track and the restrictions of the
+// active profile, returning the remaining listening time, 0 if unlimited.
+func (a *Audio) canPlay(track *Track) (time.Duration, error) {
+	now := time.Now()
+	if !track.Available(now) || !a.profile.Allows(track) {
+		return 0, fmt.Errorf("%w: %q", ErrTrackUnavailable, track.Name)
+	}
+	if a.profile == nil {
+		return 0, nil
+	}
+	if !a.profile.scheduled(now) {
+		return 0, fmt.Errorf("%w %q", ErrOutsideSchedule, a.profile.Name)
+	}
+	if a.profile.DailyQuota == 0 {
+		return 0, nil
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	listened, err := a.capabilities.ListeningTime(a.profile.ID, midnight)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get listening time of profile %q: %w", a.profile.Name, err)
+	}
+
+	remaining := time.Duration(a.profile.DailyQuota)*time.Minute - listened
+	if remaining <= 0 {
+		return 0, fmt.Errorf("%w for profile %q", ErrQuotaReached, a.profile.Name)
+	}
+	return remaining, nil
+}
+
+// checkLimits stops the playback once the listening quota is reached or the
+// schedule of the profile is over. Playbacks chosen through the API are not stopped.
+func (a *Audio) checkLimits() {
+	if a.playback == nil || a.playback.source == SourceHTTP || a.profile == nil {
+		return
+	}
+
+	now := time.Now()
+	quotaReached := !a.playback.deadline.IsZero() && !now.Before(a.playback.deadline)
+	if quotaReached || !a.profile.scheduled(now) {
+		log.Info().Msgf("Listening limit of profile %q reached", a.profile.Name)
+		a.stopPlayback(EndLimit)
+	}
+}
@@ -0,0 +1,136 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxRating is the best rating of a track.
+const MaxRating = 5
+
+// unratedWeight is the weight of the tracks without rating in a weighted random pick.
+const unratedWeight = 3
+
+var ErrInvalidRating = errors.New("invalid rating")
+
+// Rating is the opinion on a track.
+type Rating struct {
+	Favourite bool `json:"favourite"` // Favourite marks the track as a favourite.
+	Rating    int  `json:"rating"`    // Rating rates the track from 1 to 5, 0 if not rated.
+}
+
+// Validate checks that the rating is within its bounds.
+func (r Rating) Validate() error {
+	if r.Rating < 0 || r.Rating > MaxRating {
+		return fmt.Errorf("%w: %d, expected 0 to %d", ErrInvalidRating, r.Rating, MaxRating)
+	}
+	return nil
+}
+
+// RateTrack sets the rating of the track and returns the updated track.
+func (a *Audio) RateTrack(id uuid.UUID, rating Rating) (*Track, error) {
+	if err := rating.Validate(); err != nil {
+		return nil, err
+	}
+
+	reply := make(chan trackResult, 1)
+	if !a.send(rateCommand{trackID: id, rating: rating, reply: reply}) {
+		return nil, ErrClosed
+	}
+	result := <-reply
+	return result.track, result.err
+}
+
+// ToggleFavourite marks the playing track as a favourite, or unmarks it,
+// and returns the updated track.
+func (a *Audio) ToggleFavourite() (*Track, error) {
+	reply := make(chan trackResult, 1)
+	if !a.send(toggleFavouriteCommand{reply: reply}) {
+		return nil, ErrClosed
+	}
+	result := <-reply
+	return result.track, result.err
+}
+
+// rateTrack saves the rating of the track, then replaces the track.
+func (a *Audio) rateTrack(id uuid.UUID, rating Rating) (*Track, error) {
+	track, ok := a.tracks[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrTrackNotFound, id)
+	}
+
+	if err := a.capabilities.RateTrack(id, rating); err != nil {
+		return nil, fmt.Errorf("failed to rate track %q: %w", track.Name, err)
+	}
+
+	rated := *track
+	rated.Favourite = rating.Favourite
+	rated.Rating = rating.Rating
+	a.replaceTrack(&rated)
+
+	return &rated, nil
+}
+
+// replaceTrack replaces the registered track by its updated copy, including
+// the track being played.
+func (a *Audio) replaceTrack(track *Track) {
+	a.tracks[track.ID] = track
+
+	if a.playback != nil && a.playback.track.ID == track.ID {
+		a.playback.track = track
+		a.playerState.CurrentTrack = track
+	}
+}
+
+// randomTrack picks an available track, among the favourites in favourites
+// only mode, and according to the ratings if the weighted random is enabled.
+func (a *Audio) randomTrack() (uuid.UUID, error) {
+	if len(a.tracks) == 0 {
+		return uuid.Nil, ErrNoTrack
+	}
+
+	now := time.Now()
+	settings := a.currentSettings()
+	candidates := make([]*Track, 0, len(a.tracks))
+	for _, track := range a.tracks {
+		if track.Available(now) && a.profile.Allows(track) && (!settings.FavouritesOnly || track.Favourite) {
+			candidates = append(candidates, track)
+		}
+	}
+	if len(candidates) == 0 {
+		return uuid.Nil, fmt.Errorf("%w: no available track", ErrNoTrack)
+	}
+
+	if !settings.WeightedRandom {
+		return candidates[rand.Intn(len(candidates))].ID, nil
+	}
+
+	total := 0
+	for _, track := range candidates {
+		total += track.weight()
+	}
+	pick := rand.Intn(total)
+	for _, track := range candidates {
+		if pick -= track.weight(); pick < 0 {
+			return track.ID, nil
+		}
+	}
+	return candidates[len(candidates)-1].ID, nil
+}
+
+// weight is the chance of the track to be picked by a weighted random: its
+// rating, or the middle rating if not rated, doubled for favourites.
+func (t *Track) weight() int {
+	weight := t.Rating
+	if weight == 0 {
+		weight = unratedWeight
+	}
+	if t.Favourite {
+		weight *= 2
+	}
+	return weight
+}
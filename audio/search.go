@@ -0,0 +1,89 @@
+package audio
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ligatures are expanded by Fold, as they are not decomposed by the Unicode normalisation.
+var ligatures = strings.NewReplacer("œ", "oe", "Œ", "oe", "æ", "ae", "Æ", "ae", "ß", "ss")
+
+// Fold folds the case and removes the accents of the text, so that "Cœur d'été"
+// and "coeur d'ete" are equal.
+func Fold(text string) string {
+	folder := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), cases.Fold(), norm.NFC)
+	folded, _, err := transform.String(folder, ligatures.Replace(text))
+	if err != nil {
+		return strings.ToLower(text)
+	}
+	return folded
+}
+
+// TrackFilter selects the tracks of a search, its zero value matching every track.
+type TrackFilter struct {
+	Search      string   // Search are the words all found in the name or the tags, regardless of case and accents.
+	Formats     []string // Formats are the accepted formats, all if empty.
+	Collection  string   // Collection restricts the tracks to the collection and its sub-collections.
+	Favourite   *bool    // Favourite restricts the tracks to the favourite ones, or the others.
+	MinDuration int64    // MinDuration is the minimal duration in seconds, 0 for no minimum.
+	MaxDuration int64    // MaxDuration is the maximal duration in seconds, 0 for no maximum.
+}
+
+// Match tells whether the track is selected by the filter.
+func (f *TrackFilter) Match(track *Track) bool {
+	if len(f.Formats) > 0 && !containsFold(f.Formats, track.Format) {
+		return false
+	}
+	if f.Collection != "" && !inCollection(track, f.Collection) {
+		return false
+	}
+	if f.Favourite != nil && track.Favourite != *f.Favourite {
+		return false
+	}
+	if f.MinDuration > 0 && track.Duration < f.MinDuration {
+		return false
+	}
+	if f.MaxDuration > 0 && track.Duration > f.MaxDuration {
+		return false
+	}
+	return f.matchSearch(track)
+}
+
+// matchSearch tells whether all the searched words are found in the name or the tags.
+func (f *TrackFilter) matchSearch(track *Track) bool {
+	words := strings.Fields(Fold(f.Search))
+	if len(words) == 0 {
+		return true
+	}
+
+	text := Fold(strings.Join([]string{
+		track.Name, track.Tags.Title, track.Tags.Artist, track.Tags.Album, track.Tags.Genre,
+	}, " "))
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// inCollection tells whether the track belongs to the collection or one of its sub-collections.
+func inCollection(track *Track, collection string) bool {
+	collection = strings.Trim(collection, "/")
+	return track.Collection == collection || strings.HasPrefix(track.Collection, collection+"/")
+}
+
+// containsFold tells whether the values contain the value, regardless of case.
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
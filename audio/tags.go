@@ -0,0 +1,413 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/meta"
+)
+
+// Tags holds the descriptive metadata of a track.
+type Tags struct {
+	Title  string `json:"title,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	Album  string `json:"album,omitempty"`
+	Track  string `json:"track,omitempty"`
+	Genre  string `json:"genre,omitempty"`
+	Date   string `json:"date,omitempty"`
+}
+
+// IsEmpty checks whether no tag is set.
+func (t Tags) IsEmpty() bool {
+	return t == Tags{}
+}
+
+// vorbisCommentFields maps the Vorbis comment field names to the tags.
+var vorbisCommentFields = map[string]func(t *Tags) *string{
+	"TITLE":       func(t *Tags) *string { return &t.Title },
+	"ARTIST":      func(t *Tags) *string { return &t.Artist },
+	"ALBUM":       func(t *Tags) *string { return &t.Album },
+	"TRACKNUMBER": func(t *Tags) *string { return &t.Track },
+	"GENRE":       func(t *Tags) *string { return &t.Genre },
+	"DATE":        func(t *Tags) *string { return &t.Date },
+}
+
+// id3Frames maps the ID3v2 frame identifiers (v2.2 and v2.3/v2.4) to the tags.
+var id3Frames = map[string]func(t *Tags) *string{
+	"TT2":  func(t *Tags) *string { return &t.Title },
+	"TIT2": func(t *Tags) *string { return &t.Title },
+	"TP1":  func(t *Tags) *string { return &t.Artist },
+	"TPE1": func(t *Tags) *string { return &t.Artist },
+	"TAL":  func(t *Tags) *string { return &t.Album },
+	"TALB": func(t *Tags) *string { return &t.Album },
+	"TRK":  func(t *Tags) *string { return &t.Track },
+	"TRCK": func(t *Tags) *string { return &t.Track },
+	"TCO":  func(t *Tags) *string { return &t.Genre },
+	"TCON": func(t *Tags) *string { return &t.Genre },
+	"TYE":  func(t *Tags) *string { return &t.Date },
+	"TYER": func(t *Tags) *string { return &t.Date },
+	"TDRC": func(t *Tags) *string { return &t.Date },
+}
+
+// wavInfoFields lists the RIFF INFO chunk identifiers matching the tags.
+var wavInfoFields = []struct {
+	id    string
+	field func(t *Tags) *string
+}{
+	{"INAM", func(t *Tags) *string { return &t.Title }},
+	{"IART", func(t *Tags) *string { return &t.Artist }},
+	{"IPRD", func(t *Tags) *string { return &t.Album }},
+	{"ITRK", func(t *Tags) *string { return &t.Track }},
+	{"IGNR", func(t *Tags) *string { return &t.Genre }},
+	{"ICRD", func(t *Tags) *string { return &t.Date }},
+}
+
+// ReadTags reads the tags of the audio file at the given path.
+func ReadTags(path, format string) (Tags, error) {
+	switch format {
+	case "flac":
+		return readFlacTags(path)
+	case "ogg":
+		return readOggTags(path)
+	case "mp3":
+		return readID3Tags(path)
+	case "wav":
+		return readWavTags(path)
+	default:
+		return Tags{}, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+}
+
+// readVorbisComments fills the tags from "FIELD=value" comments.
+func readVorbisComments(comments [][2]string) Tags {
+	var tags Tags
+	for _, comment := range comments {
+		if field, ok := vorbisCommentFields[strings.ToUpper(comment[0])]; ok && *field(&tags) == "" {
+			*field(&tags) = strings.TrimSpace(comment[1])
+		}
+	}
+	return tags
+}
+
+// vorbisComments returns the tags as Vorbis comments.
+func (t Tags) vorbisComments() [][2]string {
+	var comments [][2]string
+	for _, name := range []string{"TITLE", "ARTIST", "ALBUM", "TRACKNUMBER", "GENRE", "DATE"} {
+		if value := *vorbisCommentFields[name](&t); value != "" {
+			comments = append(comments, [2]string{name, value})
+		}
+	}
+	return comments
+}
+
+func readFlacTags(path string) (Tags, error) {
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer stream.Close()
+
+	for _, block := range stream.Blocks {
+		if comment, ok := block.Body.(*meta.VorbisComment); ok {
+			return readVorbisComments(comment.Tags), nil
+		}
+	}
+	return Tags{}, nil
+}
+
+func readOggTags(path string) (Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+
+	reader, err := oggvorbis.NewReader(f)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	var comments [][2]string
+	for _, comment := range reader.CommentHeader().Comments {
+		if name, value, ok := strings.Cut(comment, "="); ok {
+			comments = append(comments, [2]string{name, value})
+		}
+	}
+	return readVorbisComments(comments), nil
+}
+
+// readID3Tags reads the ID3v2 tag at the start of the file, or else the ID3v1 tag at its end.
+func readID3Tags(path string) (Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+
+	tags, err := readID3v2(f)
+	if err != nil || !tags.IsEmpty() {
+		return tags, err
+	}
+	return readID3v1(f)
+}
+
+func readID3v2(r io.Reader) (Tags, error) {
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[:3]) != "ID3" {
+		return Tags{}, nil
+	}
+
+	version := header[3]
+	flags := header[5]
+	data := make([]byte, syncsafe(header[6:10]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return Tags{}, fmt.Errorf("invalid ID3v2 tag: %w", err)
+	}
+
+	// Skip the extended header
+	if flags&0x40 != 0 && len(data) >= 4 {
+		size := int(binary.BigEndian.Uint32(data[:4]))
+		if version == 3 {
+			size += 4
+		} else {
+			size = syncsafe(data[:4])
+		}
+		if size > len(data) {
+			return Tags{}, errors.New("invalid ID3v2 extended header")
+		}
+		data = data[size:]
+	}
+
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+
+	var tags Tags
+	for len(data) >= headerSize && data[0] != 0 {
+		id := string(data[:idSize])
+
+		var size int
+		switch version {
+		case 2:
+			size = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(data[4:8]))
+		default:
+			size = syncsafe(data[4:8])
+		}
+
+		if size < 0 || headerSize+size > len(data) {
+			break
+		}
+
+		if field, ok := id3Frames[id]; ok && *field(&tags) == "" {
+			*field(&tags) = decodeID3Text(data[headerSize : headerSize+size])
+		}
+		data = data[headerSize+size:]
+	}
+
+	return tags, nil
+}
+
+func readID3v1(f *os.File) (Tags, error) {
+	var tag [128]byte
+	if _, err := f.Seek(-int64(len(tag)), io.SeekEnd); err != nil {
+		return Tags{}, nil
+	}
+	if _, err := io.ReadFull(f, tag[:]); err != nil || string(tag[:3]) != "TAG" {
+		return Tags{}, nil
+	}
+
+	text := func(b []byte) string {
+		return strings.TrimSpace(decodeLatin1(bytes.TrimRight(b, "\x00 ")))
+	}
+
+	tags := Tags{
+		Title:  text(tag[3:33]),
+		Artist: text(tag[33:63]),
+		Album:  text(tag[63:93]),
+		Date:   text(tag[93:97]),
+	}
+	// ID3v1.1 stores the track number at the end of the comment
+	if tag[125] == 0 && tag[126] != 0 {
+		tags.Track = fmt.Sprint(tag[126])
+	}
+	return tags, nil
+}
+
+// syncsafe decodes a 28-bit ID3v2 sync-safe integer.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// decodeID3Text decodes an ID3v2 text frame, whose first byte is the text encoding.
+func decodeID3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	var text string
+	switch b[0] {
+	case 0: // ISO-8859-1
+		text = decodeLatin1(b[1:])
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		text = decodeUTF16(b[1:], b[0] == 2)
+	default: // UTF-8
+		text = string(b[1:])
+	}
+
+	// Only keep the first value of multi-valued frames
+	text, _, _ = strings.Cut(text, "\x00")
+	return strings.TrimSpace(text)
+}
+
+func decodeLatin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xff && b[1] == 0xfe:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xfe && b[1] == 0xff:
+			bigEndian, b = true, b[2:]
+		}
+	}
+
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// readWavTags reads the RIFF LIST/INFO chunk of a WAV file.
+func readWavTags(path string) (Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+
+	var header [12]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil ||
+		string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return Tags{}, fmt.Errorf("%w: not a RIFF/WAVE file", ErrInvalidAudioStream)
+	}
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(f, chunk[:]); err != nil {
+			return Tags{}, nil
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		if string(chunk[:4]) == "LIST" && size >= 4 && size <= 1<<20 {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(f, data); err != nil {
+				return Tags{}, nil
+			}
+			if string(data[:4]) == "INFO" {
+				return parseWavInfo(data[4:]), nil
+			}
+		} else if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+			return Tags{}, nil
+		}
+
+		// Chunks are padded to an even size
+		if size%2 == 1 {
+			if _, err := f.Seek(1, io.SeekCurrent); err != nil {
+				return Tags{}, nil
+			}
+		}
+	}
+}
+
+func parseWavInfo(data []byte) Tags {
+	var tags Tags
+	for len(data) >= 8 {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if 8+size > len(data) {
+			break
+		}
+
+		value := strings.TrimSpace(string(bytes.TrimRight(data[8:8+size], "\x00")))
+		for _, info := range wavInfoFields {
+			if info.id == id {
+				*info.field(&tags) = value
+			}
+		}
+
+		data = data[8+size+size%2:]
+	}
+	return tags
+}
+
+// writeWavTags appends a RIFF LIST/INFO chunk holding the tags to the WAV file.
+func writeWavTags(f *os.File, tags Tags) error {
+	if tags.IsEmpty() {
+		return nil
+	}
+
+	var info bytes.Buffer
+	info.WriteString("INFO")
+	for _, field := range wavInfoFields {
+		value := *field.field(&tags)
+		if value == "" {
+			continue
+		}
+		data := append([]byte(value), 0)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+		info.WriteString(field.id)
+		binary.Write(&info, binary.LittleEndian, uint32(len(data)))
+		info.Write(data)
+	}
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	// Keep the chunk aligned on an even offset
+	if end%2 == 1 {
+		if _, err := f.Write([]byte{0}); err != nil {
+			return err
+		}
+		end++
+	}
+
+	var chunk bytes.Buffer
+	chunk.WriteString("LIST")
+	binary.Write(&chunk, binary.LittleEndian, uint32(info.Len()))
+	chunk.Write(info.Bytes())
+	if _, err := f.Write(chunk.Bytes()); err != nil {
+		return err
+	}
+
+	// Update the RIFF chunk size
+	riffSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(riffSize, uint32(end+int64(chunk.Len())-8))
+	_, err = f.WriteAt(riffSize, 4)
+	return err
+}
@@ -1,10 +1,15 @@
 package audio
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gopxl/beep"
@@ -12,9 +17,9 @@ import (
 	"github.com/gopxl/beep/mp3"
 	"github.com/gopxl/beep/vorbis"
 	"github.com/gopxl/beep/wav"
+	"github.com/rs/zerolog/log"
 )
 
-var namespace = uuid.MustParse("a1536713-cf3b-4c19-8ffb-f2f48d99a4e4")
 var supportedFormats = map[string]struct{}{
 	".flac": {},
 	".ogg":  {},
@@ -22,18 +27,54 @@ var supportedFormats = map[string]struct{}{
 	".wav":  {},
 }
 
+// contentTypes maps the track formats to their MIME type.
+var contentTypes = map[string]string{
+	"flac": "audio/flac",
+	"ogg":  "audio/ogg",
+	"mp3":  "audio/mpeg",
+	"wav":  "audio/wav",
+}
+
 // isSupportedFormat checks if the file extension is supported for audio tracks.
 func isSupportedFormat(ext string) bool {
 	_, supported := supportedFormats[strings.ToLower(ext)]
 	return supported
 }
 
+// formatFromExt returns the track format matching the given file extension.
+func formatFromExt(ext string) (string, error) {
+	switch strings.ToLower(ext) {
+	case ".flac":
+		return "flac", nil
+	case ".ogg":
+		return "ogg", nil
+	case ".mp3":
+		return "mp3", nil
+	case ".wav":
+		return "wav", nil
+	default:
+		// Return an error if the file format is not supported.
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedFormat, ext)
+	}
+}
+
 // Track represents an individual audio track, including its file path, format, and index in the track list.
 type Track struct {
-	ID     uuid.UUID `json:"id"`     // Is an unique identifier depending on file path to the audio track.
-	Path   string    `json:"path"`   // Path is the file path to the audio track.
-	Format string    `json:"format"` // Format is the audio format of the track (e.g., mp3, wav).
-	Name   string    `json:"name"`   // Name is the file name of the audio track.
+	ID          uuid.UUID `json:"id"`          // ID is assigned once when the track is first registered and survives rename or move.
+	Path        string    `json:"path"`        // Path is the file path to the audio track.
+	Format      string    `json:"format"`      // Format is the audio format of the track (e.g., mp3, wav).
+	Name        string    `json:"name"`        // Name is the file name of the audio track.
+	Collection  string    `json:"collection"`  // Collection is the directory of the track relative to the storage path.
+	Fingerprint string    `json:"fingerprint"` // Fingerprint identifies the track content, regardless of its path.
+	Tags        Tags      `json:"tags"`        // Tags holds the metadata read from the file.
+	Duration    int64     `json:"duration"`    // Duration is the duration of the track in seconds, 0 if unknown.
+	AddedAt     time.Time `json:"added_at"`    // AddedAt is when the track was first registered.
+	Favourite   bool      `json:"favourite"`   // Favourite marks the track as a favourite.
+	Rating      int       `json:"rating"`      // Rating rates the track from 1 to 5, 0 if not rated.
+
+	Hidden          bool         `json:"hidden"`           // Hidden hides the track from the children and the random play.
+	DisabledUntil   *time.Time   `json:"disabled_until"`   // DisabledUntil disables the track until the given time, if set.
+	DisabledWindows []TimeWindow `json:"disabled_windows"` // DisabledWindows disable the track every day during the windows.
 }
 
 // NewTrack creates a new Track instance from a given file path.
@@ -44,29 +85,106 @@ func NewTrack(path string) (*Track, error) {
 		return nil, fmt.Errorf("file does not exist: %s", path)
 	}
 
-	// Extract the file extension and convert it to lower case.
-	ext := strings.ToLower(filepath.Ext(path))
-	format := ""
-	switch ext {
-	case ".flac":
-		format = "flac"
-	case ".ogg":
-		format = "ogg"
-	case ".mp3":
-		format = "mp3"
-	case ".wav":
-		format = "wav"
-	default:
-		// Return an error if the file format is not supported.
-		return nil, fmt.Errorf("unsupported file format: %s", ext)
+	format, err := formatFromExt(filepath.Ext(path))
+	if err != nil {
+		return nil, err
+	}
+
+	// Compute the content fingerprint used to recognise the track once moved.
+	fingerprint, err := Fingerprint(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint %s: %w", path, err)
 	}
 
-	// Extract id & file name from the path.
-	id := uuid.NewMD5(namespace, []byte(path))
-	name := filepath.Base(path)
+	// Missing or broken tags must not prevent the track from being played.
+	tags, err := ReadTags(path, format)
+	if err != nil {
+		log.Debug().Err(err).Msgf("Unable to read the tags of %s", path)
+	}
 
-	// Return a new Track instance with the determined path, format, index, and name.
-	return &Track{ID: id, Path: path, Format: format, Name: name}, nil
+	// The ID is left empty: it is assigned when the track gets registered.
+	track := &Track{
+		Path:        path,
+		Format:      format,
+		Name:        filepath.Base(path),
+		Fingerprint: fingerprint,
+		Tags:        tags,
+	}
+
+	// An undecodable track is still listed, with an unknown duration
+	if track.Duration, err = track.readDuration(); err != nil {
+		log.Debug().Err(err).Msgf("Unable to read the duration of %s", path)
+	}
+
+	return track, nil
+}
+
+// readDuration decodes the header of the track file to get its duration in seconds.
+func (t *Track) readDuration() (int64, error) {
+	file, err := t.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	streamer, format, err := t.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+	defer streamer.Close()
+
+	return int64(format.SampleRate.D(streamer.Len()).Seconds()), nil
+}
+
+// fingerprintChunkSize is the size of the head and tail chunks hashed by Fingerprint.
+const fingerprintChunkSize = 64 * 1024
+
+// Fingerprint computes a content fingerprint of the file at the given path.
+// It hashes the file size along with its first and last chunks, which is
+// enough to tell audio files apart without reading them entirely on the Pi.
+func Fingerprint(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	if err := binary.Write(hash, binary.LittleEndian, info.Size()); err != nil {
+		return "", err
+	}
+
+	// Hash the head of the file
+	if _, err := io.CopyN(hash, f, fingerprintChunkSize); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	// Hash the tail of the file when it was not already covered by the head
+	if info.Size() > 2*fingerprintChunkSize {
+		if _, err := f.Seek(-fingerprintChunkSize, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(hash, f, fingerprintChunkSize); err != nil && err != io.EOF {
+			return "", err
+		}
+	} else if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ContentType returns the MIME type of the track file.
+func (t *Track) ContentType() string {
+	if contentType, ok := contentTypes[t.Format]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
 }
 
 // Open opens the track file.
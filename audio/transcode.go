@@ -0,0 +1,234 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/wav"
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+	"github.com/rs/zerolog/log"
+)
+
+// flacBlockSize is the number of samples per channel of each encoded FLAC frame.
+const flacBlockSize = 4096
+
+// flacMaxRiceParam is the highest Rice parameter of the rice1 residual coding, 15 being the escape code.
+const flacMaxRiceParam = 14
+
+// resampleQuality is the quality of the resampling done while transcoding.
+const resampleQuality = 4
+
+// canonicalFormats lists the formats tracks can be transcoded to.
+var canonicalFormats = map[string]struct{}{
+	"wav":  {},
+	"flac": {},
+}
+
+// writeSeeker hides the Close method of the wrapped file from the FLAC encoder.
+type writeSeeker struct {
+	io.WriteSeeker
+}
+
+// transcode re-encodes the audio file at the given path to the canonical format
+// at the output sample rate, keeping its tags. It returns the path of the
+// transcoded temporary file, or the given path when there is nothing to do.
+func (a *Audio) transcode(path string) (string, error) {
+	if a.transcodeFormat == "" {
+		return path, nil
+	}
+
+	format, err := formatFromExt(filepath.Ext(path))
+	if err != nil {
+		return "", err
+	}
+
+	track := &Track{Path: path, Format: format}
+	file, err := track.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	streamer, streamFormat, err := track.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAudioStream, err)
+	}
+	defer streamer.Close()
+
+	if format == a.transcodeFormat && streamFormat.SampleRate == a.sampleRate {
+		return path, nil
+	}
+
+	tags, err := ReadTags(path, format)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Unable to read the tags of %s", path)
+	}
+
+	var source beep.Streamer = streamer
+	if streamFormat.SampleRate != a.sampleRate {
+		source = beep.Resample(resampleQuality, streamFormat.SampleRate, a.sampleRate, streamer)
+	}
+
+	out, err := os.CreateTemp(a.storagePath, uploadTempPrefix+"*."+a.transcodeFormat)
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary file: %w", err)
+	}
+
+	outFormat := beep.Format{SampleRate: a.sampleRate, NumChannels: 2, Precision: 2}
+	switch a.transcodeFormat {
+	case "wav":
+		err = wav.Encode(out, source, outFormat)
+		if err == nil {
+			err = writeWavTags(out, tags)
+		}
+	case "flac":
+		err = encodeFlac(writeSeeker{out}, source, outFormat, tags)
+	}
+	if err == nil {
+		err = streamer.Err()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to transcode %s to %s: %w", path, a.transcodeFormat, err)
+	}
+
+	log.Info().Msgf("Transcoded %s from %s to %s", path, format, a.transcodeFormat)
+	return out.Name(), nil
+}
+
+// encodeFlac encodes the streamer as 16-bit stereo FLAC, using a fixed
+// second order predictor which is cheap enough to run on the Pi.
+func encodeFlac(w io.WriteSeeker, s beep.Streamer, format beep.Format, tags Tags) error {
+	info := &meta.StreamInfo{
+		BlockSizeMin:  flacBlockSize,
+		BlockSizeMax:  flacBlockSize,
+		SampleRate:    uint32(format.SampleRate),
+		NChannels:     2,
+		BitsPerSample: 16,
+	}
+
+	var blocks []*meta.Block
+	if !tags.IsEmpty() {
+		comment := &meta.VorbisComment{Vendor: "hifi-baby", Tags: tags.vorbisComments()}
+		blocks = append(blocks, &meta.Block{
+			Header: meta.Header{Type: meta.TypeVorbisComment, Length: vorbisCommentLength(comment)},
+			Body:   comment,
+		})
+	}
+
+	encoder, err := flac.NewEncoder(w, info, blocks...)
+	if err != nil {
+		return err
+	}
+
+	samples := make([][2]float64, flacBlockSize)
+	for {
+		// Fill a whole block as only the last frame may be shorter
+		n, ok := 0, true
+		for n < len(samples) && ok {
+			var read int
+			read, ok = s.Stream(samples[n:])
+			n += read
+		}
+
+		if n > 0 {
+			if err := encoder.WriteFrame(flacFrame(samples[:n], format.SampleRate)); err != nil {
+				return err
+			}
+		}
+
+		if !ok {
+			break
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// flacFrame builds a stereo FLAC frame from the given samples.
+func flacFrame(samples [][2]float64, sampleRate beep.SampleRate) *frame.Frame {
+	subframes := make([]*frame.Subframe, 2)
+	for channel := range subframes {
+		values := make([]int32, len(samples))
+		for i, sample := range samples {
+			values[i] = int32(math.Round(math.Max(-1, math.Min(1, sample[channel])) * math.MaxInt16))
+		}
+		subframes[channel] = flacSubframe(values)
+	}
+
+	return &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         uint16(len(samples)),
+			SampleRate:        uint32(sampleRate),
+			Channels:          frame.ChannelsLR,
+			BitsPerSample:     16,
+		},
+		Subframes: subframes,
+	}
+}
+
+// flacSubframe encodes the values with a fixed second order predictor,
+// choosing the Rice parameter from the mean of the residuals.
+func flacSubframe(values []int32) *frame.Subframe {
+	const order = 2
+
+	subframe := &frame.Subframe{
+		SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+		Samples:   values,
+		NSamples:  len(values),
+	}
+	if len(values) <= order {
+		return subframe
+	}
+
+	var sum int64
+	for i := order; i < len(values); i++ {
+		residual := int64(values[i]) - 2*int64(values[i-1]) + int64(values[i-2])
+		if residual < 0 {
+			residual = -residual
+		}
+		sum += residual
+	}
+
+	param := uint(0)
+	for mean := sum / int64(len(values)-order); mean > 1 && param < flacMaxRiceParam; mean >>= 1 {
+		param++
+	}
+
+	subframe.SubHeader = frame.SubHeader{
+		Pred:                 frame.PredFixed,
+		Order:                order,
+		ResidualCodingMethod: frame.ResidualCodingMethodRice1,
+		RiceSubframe: &frame.RiceSubframe{
+			Partitions: []frame.RicePartition{{Param: param}},
+		},
+	}
+	return subframe
+}
+
+// vorbisCommentLength returns the size in bytes of the encoded Vorbis comment block body.
+func vorbisCommentLength(comment *meta.VorbisComment) int64 {
+	length := int64(4 + len(comment.Vendor) + 4)
+	for _, tag := range comment.Tags {
+		length += int64(4 + len(tag[0]) + 1 + len(tag[1]))
+	}
+	return length
+}
@@ -0,0 +1,308 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register the decoders of the cover formats
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// uploadTempPrefix prefixes the temporary files created while a track is uploaded.
+const uploadTempPrefix = ".upload-"
+
+// maxFileNameLength is the maximum length in bytes of a stored file name.
+const maxFileNameLength = 255
+
+// maxExtensionLength is the maximum length in bytes of an extension kept when
+// a file name is truncated. Longer ones are truncated with the rest of the name.
+const maxExtensionLength = 16
+
+// maxCoverSize is the maximum size in bytes of a cover image.
+const maxCoverSize = 10 << 20
+
+// coverFormats lists the extensions of the cover images stored along the tracks.
+var coverFormats = map[string]struct{}{
+	".jpg":  {},
+	".jpeg": {},
+	".png":  {},
+}
+
+// probeSamples is the number of samples decoded to check that an upload is valid audio.
+const probeSamples = 4096
+
+var (
+	ErrInvalidFileName    = errors.New("invalid file name")
+	ErrUnsupportedFormat  = errors.New("unsupported file format")
+	ErrFileTooLarge       = errors.New("file is too large")
+	ErrInvalidAudioStream = errors.New("invalid audio stream")
+	ErrInvalidImage       = errors.New("invalid image")
+)
+
+// AddTrack stores the uploaded track under a sanitized version of the given name
+// in the given collection (a sub-directory of the storage path) and registers it.
+// The content is written to a temporary file, checked to be decodable audio,
+// optionally transcoded to the canonical format, then atomically renamed next
+// to the other tracks without overwriting any of them.
+func (a *Audio) AddTrack(collection, name string, src io.Reader) (*Track, error) {
+	fullPath, err := a.store(collection, name, src, a.maxTrackSize, func(path string) (string, error) {
+		format, err := formatFromExt(filepath.Ext(path))
+		if err != nil {
+			return "", err
+		}
+		if err := probeTrack(path, format); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidAudioStream, err)
+		}
+		return a.transcode(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	track, err := a.addTrack(fullPath)
+	if err != nil {
+		os.Remove(fullPath)
+		return nil, err
+	}
+
+	return track, nil
+}
+
+// AddCover stores the uploaded cover image in the given collection.
+// It returns the path of the stored image.
+func (a *Audio) AddCover(collection, name string, src io.Reader) (string, error) {
+	return a.store(collection, name, src, maxCoverSize, func(path string) (string, error) {
+		if !IsCoverFormat(filepath.Ext(path)) {
+			return "", fmt.Errorf("%w: %q", ErrUnsupportedFormat, filepath.Ext(path))
+		}
+		return path, probeCover(path)
+	})
+}
+
+// store writes the source to a temporary file of at most maxSize bytes,
+// prepares it, then renames it to a free name in the collection directory.
+// The prepare function validates the temporary file and returns the path of
+// the file to store, whose extension replaces the one of the given name.
+// It returns the path of the stored file.
+func (a *Audio) store(
+	collection, name string,
+	src io.Reader,
+	maxSize int64,
+	prepare func(path string) (string, error),
+) (string, error) {
+	dir, err := sanitizeCollection(collection)
+	if err != nil {
+		return "", err
+	}
+
+	fileName, err := sanitizeFileName(name)
+	if err != nil {
+		return "", err
+	}
+
+	base, ext := splitExt(fileName)
+	tmpPath, err := a.writeTempFile(src, ext, maxSize)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath) // No-op once the file has been renamed
+
+	preparedPath, err := prepare(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	if preparedPath != tmpPath {
+		defer os.Remove(preparedPath)
+		fileName = base + filepath.Ext(preparedPath)
+	}
+
+	return a.storeTempFile(preparedPath, dir, fileName)
+}
+
+// writeTempFile copies the source into a temporary file of the storage path,
+// enforcing the maximum size. It returns the temporary file path.
+func (a *Audio) writeTempFile(src io.Reader, ext string, maxSize int64) (string, error) {
+	tmp, err := os.CreateTemp(a.storagePath, uploadTempPrefix+"*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary file: %w", err)
+	}
+
+	written, err := io.Copy(tmp, io.LimitReader(src, maxSize+1))
+	if err == nil && written > maxSize {
+		err = fmt.Errorf("%w: exceeds %d bytes", ErrFileTooLarge, maxSize)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// storeTempFile renames the temporary file to a free name derived from fileName in the dir collection.
+func (a *Audio) storeTempFile(tmpPath, dir, fileName string) (string, error) {
+	a.storeMutex.Lock()
+	defer a.storeMutex.Unlock()
+
+	dirPath := filepath.Join(a.storagePath, dir)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create collection %q: %w", dir, err)
+	}
+
+	fullPath, err := availablePath(filepath.Join(dirPath, fileName))
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		return "", fmt.Errorf("failed to save the file %q: %w", fullPath, err)
+	}
+
+	return fullPath, nil
+}
+
+// sanitizeCollection sanitizes each segment of a collection path.
+// It refuses any segment that would escape the storage path.
+func sanitizeCollection(collection string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(strings.ReplaceAll(collection, "\\", "/"), "/") {
+		if strings.TrimSpace(segment) == "" {
+			continue
+		}
+		sanitized, err := sanitizeFileName(segment)
+		if err != nil {
+			return "", err
+		}
+		segments = append(segments, sanitized)
+	}
+	return filepath.Join(segments...), nil
+}
+
+// sanitizeFileName keeps only the base name of an uploaded file and strips
+// any character that could be misinterpreted by the file system.
+func sanitizeFileName(name string) (string, error) {
+	// Clients may send Windows paths
+	name = strings.ReplaceAll(name, "\\", "/")
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	// Refuse hidden files, which are also used for temporary uploads
+	if name == "" || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+	}
+
+	base, ext := splitExt(name)
+	return fitFileName(base, "", ext), nil
+}
+
+// splitExt splits the file name into its base name and extension, the
+// extension being empty if longer than maxExtensionLength.
+func splitExt(name string) (string, string) {
+	ext := filepath.Ext(name)
+	if len(ext) > maxExtensionLength {
+		return name, ""
+	}
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// fitFileName joins the base name, suffix and extension of a file name,
+// truncating the base name to keep it within maxFileNameLength bytes.
+func fitFileName(base, suffix, ext string) string {
+	if room := maxFileNameLength - len(suffix) - len(ext); len(base) > room {
+		base = strings.ToValidUTF8(base[:room], "")
+	}
+	return base + suffix + ext
+}
+
+// availablePath returns the given path, or a variant suffixed with a counter
+// (e.g. "song (1).mp3") if a file already exists there. The file name is
+// truncated to fit maxFileNameLength with its suffix.
+func availablePath(fullPath string) (string, error) {
+	dir, name := filepath.Split(fullPath)
+	base, ext := splitExt(name)
+
+	candidate := filepath.Join(dir, fitFileName(base, "", ext))
+	for idx := 1; ; idx++ {
+		_, err := os.Stat(candidate)
+		if os.IsNotExist(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = filepath.Join(dir, fitFileName(base, fmt.Sprintf(" (%d)", idx), ext))
+	}
+}
+
+// isCoverFormat checks if the file extension is supported for cover images.
+func IsCoverFormat(ext string) bool {
+	_, supported := coverFormats[strings.ToLower(ext)]
+	return supported
+}
+
+// probeCover checks that the file at the given path decodes as an image.
+func probeCover(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, _, err := image.DecodeConfig(file); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return nil
+}
+
+// probeTrack checks that the file at the given path decodes as audio of the given format.
+func probeTrack(path string, format string) error {
+	track := &Track{Path: path, Format: format}
+
+	file, err := track.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	streamer, streamFormat, err := track.Decode(file)
+	if err != nil {
+		return err
+	}
+	defer streamer.Close()
+
+	if streamFormat.SampleRate <= 0 || streamFormat.NumChannels <= 0 {
+		return fmt.Errorf("invalid format %+v", streamFormat)
+	}
+
+	samples := make([][2]float64, probeSamples)
+	n, ok := streamer.Stream(samples)
+	if err := streamer.Err(); err != nil {
+		return err
+	}
+	if !ok || n == 0 {
+		return errors.New("no audio samples")
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	longExt := "x." + strings.Repeat("y", 300)
+
+	tests := []struct {
+		name     string
+		expected string
+		err      error
+	}{
+		{name: "song.mp3", expected: "song.mp3"},
+		{name: `C:\Music\song.mp3`, expected: "song.mp3"},
+		{name: "../../song.mp3", expected: "song.mp3"},
+		{name: ` so<n>g?.mp3 `, expected: "song.mp3"},
+		{name: ".hidden.mp3", err: ErrInvalidFileName},
+		{name: "  ", err: ErrInvalidFileName},
+		{name: strings.Repeat("a", 300) + ".mp3", expected: strings.Repeat("a", 251) + ".mp3"},
+		{name: strings.Repeat("é", 200) + ".mp3", expected: strings.Repeat("é", 125) + ".mp3"},
+		{name: longExt, expected: longExt[:maxFileNameLength]},
+		{name: "Vol. 1 of the adventures", expected: "Vol. 1 of the adventures"},
+	}
+	for _, test := range tests {
+		name, err := sanitizeFileName(test.name)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%q: expected error %v, got %v", test.name, test.err, err)
+		}
+		if name != test.expected {
+			t.Fatalf("%q: expected %q, got %q", test.name, test.expected, name)
+		}
+		if len(name) > maxFileNameLength || !utf8.ValidString(name) {
+			t.Fatalf("%q: invalid file name %q", test.name, name)
+		}
+	}
+}
+
+func TestAvailablePath(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"song.mp3", strings.Repeat("a", 251) + ".mp3", "x." + strings.Repeat("y", 253)} {
+		for range 3 {
+			path, err := availablePath(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The counter suffix is kept within the limit
+			if len(filepath.Base(path)) > maxFileNameLength {
+				t.Fatalf("file name of %d bytes: %q", len(filepath.Base(path)), path)
+			}
+			if err := os.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 9 {
+		t.Fatalf("expected 9 distinct files, got %d", len(files))
+	}
+	if _, err := os.Stat(filepath.Join(dir, strings.Repeat("a", 247)+" (2).mp3")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddTrackLongExtension(t *testing.T) {
+	dir := t.TempDir()
+	a, _, _ := newTestAudio(t, context.Background(), Config{StoragePath: dir, MaxTrackSize: 1 << 20}, testSettings)
+
+	name := "x." + strings.Repeat("y", 300)
+	if _, err := a.AddTrack("", name, strings.NewReader("not audio")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+	if _, err := a.AddCover("", name, strings.NewReader("not an image")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+	"github.com/OhohLeo/hifi-baby/settings"
+	"github.com/OhohLeo/hifi-baby/sql"
+)
+
+// formatVersion is the version of the archive layout.
+const formatVersion = 1
+
+// Names of the archive entries.
+const (
+	manifestEntry = "manifest.json"
+	databaseEntry = "hifi-baby.db"
+	settingsEntry = "settings.json"
+	tracksDir     = "tracks/"
+)
+
+var ErrInvalidBackup = errors.New("invalid backup")
+
+type Config struct {
+	Dir            string        `env:"BACKUP_DIR"`                                 // Dir is where the scheduled backups are written, such as a USB stick, disabled if empty.
+	Interval       time.Duration `env:"BACKUP_INTERVAL,default=24h"`                // Interval is the interval between two scheduled backups.
+	Keep           int           `env:"BACKUP_KEEP,default=7"`                      // Keep is the number of scheduled backups kept.
+	Tracks         bool          `env:"BACKUP_TRACKS,default=false"`                // Tracks adds the tracks to the scheduled backups.
+	MaxRestoreSize int64         `env:"BACKUP_MAX_RESTORE_SIZE,default=8589934592"` // MaxRestoreSize is the maximum size in bytes extracted from a restored backup.
+}
+
+// Manifest describes the content of a backup.
+type Manifest struct {
+	Version       int       `json:"version"`        // Version is the version of the archive layout.
+	CreatedAt     time.Time `json:"created_at"`     // CreatedAt is when the backup was made.
+	SchemaVersion int       `json:"schema_version"` // SchemaVersion is the version of the database schema.
+	Tracks        bool      `json:"tracks"`         // Tracks tells whether the tracks are included.
+}
+
+// RestoreReport describes a restored backup.
+type RestoreReport struct {
+	Manifest *Manifest           `json:"manifest"`
+	Tracks   *audio.ImportReport `json:"tracks,omitempty"` // Tracks is the outcome of the restored tracks, if any.
+}
+
+// Manager backs up and restores the database, the settings and the tracks.
+type Manager struct {
+	config       Config
+	storagePath  string // storagePath is where the tracks are stored.
+	databasePath string // databasePath is the database file, next to which temporary files are written.
+	audio        *audio.Audio
+	database     *sql.Database
+	settings     *settings.Store
+	restoreMutex sync.Mutex // restoreMutex serialises the restores.
+}
+
+// NewManager creates a backup manager.
+func NewManager(
+	config Config,
+	audioConfig audio.Config,
+	databaseConfig sql.Config,
+	audio *audio.Audio,
+	database *sql.Database,
+	settings *settings.Store,
+) *Manager {
+	return &Manager{
+		config:       config,
+		storagePath:  audioConfig.StoragePath,
+		databasePath: databaseConfig.Path,
+		audio:        audio,
+		database:     database,
+		settings:     settings,
+	}
+}
+
+// tempDir creates a temporary directory next to the database, on the SD card
+// rather than in memory.
+func (m *Manager) tempDir() (string, error) {
+	return os.MkdirTemp(filepath.Dir(m.databasePath), ".backup-*")
+}
+
+// Write streams a gzipped tar archive holding a snapshot of the database, the
+// settings file and, if withTracks is set, the files of the storage path.
+func (m *Manager) Write(w io.Writer, withTracks bool) error {
+	schemaVersion, err := m.database.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := m.tempDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshot := filepath.Join(tmpDir, databaseEntry)
+	if err := m.database.Snapshot(snapshot); err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	manifest, err := json.MarshalIndent(&Manifest{
+		Version:       formatVersion,
+		CreatedAt:     time.Now(),
+		SchemaVersion: schemaVersion,
+		Tracks:        withTracks,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(archive, manifestEntry, int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		return err
+	}
+
+	for name, filePath := range map[string]string{databaseEntry: snapshot, settingsEntry: m.settings.Path()} {
+		if err := writeFile(archive, name, filePath); err != nil {
+			return err
+		}
+	}
+
+	if withTracks {
+		err := filepath.Walk(m.storagePath, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Temporary files, such as uploads in progress, are hidden
+			if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+				return nil
+			}
+
+			rel, err := filepath.Rel(m.storagePath, filePath)
+			if err != nil {
+				return err
+			}
+			return writeFile(archive, tracksDir+filepath.ToSlash(rel), filePath)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to back up tracks: %w", err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeFile adds the file to the archive under the given name.
+func writeFile(archive *tar.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeEntry(archive, name, info.Size(), file)
+}
+
+func writeEntry(archive *tar.Writer, name string, size int64, src io.Reader) error {
+	err := archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(archive, io.LimitReader(src, size))
+	return err
+}
+
+// Restore extracts an archive written by Write, checks it, then replaces the
+// database and the settings and adds the missing tracks. Nothing is applied if
+// the database or the settings of the archive are invalid.
+func (m *Manager) Restore(src io.Reader) (*RestoreReport, error) {
+	m.restoreMutex.Lock()
+	defer m.restoreMutex.Unlock()
+
+	tmpDir, err := m.tempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := m.extract(src, tmpDir); err != nil {
+		return nil, err
+	}
+
+	report := &RestoreReport{}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, manifestEntry))
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing manifest", ErrInvalidBackup)
+	}
+	if err := json.Unmarshal(data, &report.Manifest); err != nil {
+		return nil, fmt.Errorf("%w: invalid manifest: %v", ErrInvalidBackup, err)
+	}
+	if report.Manifest.Version != formatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBackup, report.Manifest.Version)
+	}
+
+	databasePath := filepath.Join(tmpDir, databaseEntry)
+	if err := sql.CheckBackup(databasePath); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	settingsFile, err := os.Open(filepath.Join(tmpDir, settingsEntry))
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing settings", ErrInvalidBackup)
+	}
+	defer settingsFile.Close()
+	restoredSettings, err := settings.Decode(settingsFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	if err := m.database.RestoreFrom(databasePath); err != nil {
+		return nil, err
+	}
+	if _, err := m.settings.Update(restoredSettings); err != nil {
+		return nil, err
+	}
+
+	if report.Manifest.Tracks {
+		report.Tracks, err = m.restoreTracks(filepath.Join(tmpDir, tracksDir))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The tracks are registered again against the restored database
+	if err := m.audio.Rescan(); err != nil {
+		return nil, fmt.Errorf("failed to reload tracks: %w", err)
+	}
+
+	log.Info().Msgf("Backup of %s restored", report.Manifest.CreatedAt.Format(time.RFC3339))
+	return report, nil
+}
+
+// extract writes the regular files of the archive to the directory, within
+// the maximum restore size.
+func (m *Manager) extract(src io.Reader, dir string) error {
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer gz.Close()
+
+	remaining := m.config.MaxRestoreSize
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+
+		// Links are skipped as they could point outside of the directory
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean("/" + header.Name)[1:]
+		if name != manifestEntry && name != databaseEntry && name != settingsEntry &&
+			!strings.HasPrefix(name, tracksDir) {
+			continue
+		}
+
+		if header.Size > remaining {
+			return fmt.Errorf("%w: exceeds %d bytes", audio.ErrImportTooLarge, m.config.MaxRestoreSize)
+		}
+		remaining -= header.Size
+
+		if err := extractFile(archive, filepath.Join(dir, filepath.FromSlash(name)), header.Size); err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(src io.Reader, filePath string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, io.LimitReader(src, size))
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	return nil
+}
+
+// restoreTracks adds the extracted files missing from the storage path.
+func (m *Manager) restoreTracks(dir string) (*audio.ImportReport, error) {
+	report := &audio.ImportReport{}
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		report.Add(m.audio.ImportFile(filepath.ToSlash(rel), file))
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore tracks: %w", err)
+	}
+
+	return report, nil
+}
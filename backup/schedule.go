@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Scheduled backups are named hifi-baby-<timestamp>.tar.gz.
+const (
+	scheduledPrefix = "hifi-baby-"
+	scheduledSuffix = ".tar.gz"
+	scheduledLayout = "20060102-150405"
+)
+
+// Run writes a backup to the backup directory at every interval until the
+// context is done, the first one as soon as the last backup is older than the
+// interval, so that a box often switched off is still backed up.
+func (m *Manager) Run(ctx context.Context) {
+	if m.config.Dir == "" || m.config.Interval <= 0 {
+		log.Info().Msg("Scheduled backups disabled")
+		return
+	}
+
+	for {
+		delay := time.Duration(0)
+		if backups, err := m.scheduledBackups(); err == nil && len(backups) > 0 {
+			delay = time.Until(backups[0].ModTime().Add(m.config.Interval))
+		}
+
+		timer := time.NewTimer(max(delay, 0))
+		select {
+		case <-timer.C:
+			if err := m.writeScheduled(); err != nil {
+				log.Error().Err(err).Msg("Scheduled backup failed")
+				// Retry at the next interval rather than in a loop
+				if !sleep(ctx, m.config.Interval) {
+					return
+				}
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// sleep waits for the duration, returning false if the context is done first.
+func sleep(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeScheduled writes a backup to the backup directory, then removes the
+// oldest backups beyond the number of backups kept.
+func (m *Manager) writeScheduled() error {
+	if err := os.MkdirAll(m.config.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := scheduledPrefix + time.Now().Format(scheduledLayout) + scheduledSuffix
+	tmp, err := os.CreateTemp(m.config.Dir, "."+name+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = m.Write(tmp, m.config.Tracks)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only complete backups get their final name
+	if err := os.Rename(tmp.Name(), filepath.Join(m.config.Dir, name)); err != nil {
+		return err
+	}
+	log.Info().Msgf("Backup written to %s", filepath.Join(m.config.Dir, name))
+
+	return m.rotate()
+}
+
+// rotate removes the oldest scheduled backups beyond the number of backups kept.
+func (m *Manager) rotate() error {
+	backups, err := m.scheduledBackups()
+	if err != nil {
+		return err
+	}
+
+	for _, backup := range backups[min(len(backups), max(m.config.Keep, 1)):] {
+		if err := os.Remove(filepath.Join(m.config.Dir, backup.Name())); err != nil {
+			return err
+		}
+		log.Info().Msgf("Old backup %s removed", backup.Name())
+	}
+
+	return nil
+}
+
+// scheduledBackups returns the scheduled backups of the backup directory, most recent first.
+func (m *Manager) scheduledBackups() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(m.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []os.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, scheduledPrefix) || !strings.HasSuffix(name, scheduledSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, info)
+	}
+
+	// The timestamp of the name sorts the backups
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name() > backups[j].Name()
+	})
+
+	return backups, nil
+}
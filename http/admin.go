@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+	"github.com/OhohLeo/hifi-baby/backup"
+)
+
+// backup streams a backup of the database and the settings, with the tracks
+// if the "tracks" query parameter is true.
+func (s *Server) backup(w http.ResponseWriter, r *http.Request) {
+	withTracks := false
+	if value := r.URL.Query().Get("tracks"); value != "" {
+		var err error
+		if withTracks, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid tracks, expected true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	name := "hifi-baby-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	if err := s.backups.Write(w, withTracks); err != nil {
+		// The response has already started: the client gets a truncated archive
+		log.Error().Err(err).Msg("Failed to write backup")
+	}
+}
+
+// restore restores a backup, sent either as the request body or as the
+// "backup" multipart file.
+func (s *Server) restore(w http.ResponseWriter, r *http.Request) {
+	src := r.Body
+	if reader, err := r.MultipartReader(); err == nil {
+		src = nil
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() == "backup" {
+				src = part
+				break
+			}
+			part.Close()
+		}
+	}
+
+	if src == nil {
+		http.Error(w, "Invalid backup upload: missing 'backup' file", http.StatusBadRequest)
+		return
+	}
+
+	report, err := s.backups.Restore(src)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to restore backup")
+		status := http.StatusInternalServerError
+		if errors.Is(err, backup.ErrInvalidBackup) || errors.Is(err, audio.ErrImportTooLarge) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "Failed to restore backup: "+err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireParent restricts the route to parents, identified by the configured parent token.
+// The token is sent as "Authorization: Bearer <token>", or as the "token" query
+// parameter for the browser elements that cannot set headers (e.g. <audio>).
+// When no token is configured, every request is allowed.
+func (s *Server) requireParent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isParent(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hifi-baby"`)
+			http.Error(w, "Parent authorization required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isParent tells whether the request is sent by a parent, always true when no
+// token is configured.
+func (s *Server) isParent(r *http.Request) bool {
+	if s.config.ParentToken == "" {
+		return true
+	}
+
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.ParentToken)) == 1
+}
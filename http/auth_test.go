@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireParent(t *testing.T) {
+	server := NewServer(nil, Config{ParentToken: "secret"}, nil, nil, nil)
+
+	// The listening history of the profiles is only shown to the parents
+	routes := []struct{ method, path string }{
+		{http.MethodGet, "/audio/tracks/listened"},
+		{http.MethodGet, "/audio/tracks/listened/daily"},
+		{http.MethodGet, "/audio/tracks/most-listened"},
+		{http.MethodGet, "/stats/daily"},
+		{http.MethodGet, "/stats/heatmap"},
+		{http.MethodGet, "/stats/tracks"},
+		{http.MethodGet, "/stats/summary"},
+		{http.MethodGet, "/history/export"},
+		{http.MethodGet, "/profiles/"},
+		{http.MethodPut, "/profiles/active"},
+		{http.MethodPut, "/settings"},
+	}
+	for _, route := range routes {
+		for _, token := range []string{"", "wrong"} {
+			request := httptest.NewRequest(route.method, route.path, nil)
+			if token != "" {
+				request.Header.Set("Authorization", "Bearer "+token)
+			}
+			recorder := httptest.NewRecorder()
+			server.router.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusUnauthorized {
+				t.Fatalf("%s %s with token %q: expected %d, got %d",
+					route.method, route.path, token, http.StatusUnauthorized, recorder.Code)
+			}
+		}
+	}
+}
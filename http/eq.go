@@ -0,0 +1,97 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+	"github.com/OhohLeo/hifi-baby/settings"
+)
+
+// eqState is the equalizer applied to the playback and the available presets.
+type eqState struct {
+	EQ      audio.EQ         `json:"eq"`
+	Presets []audio.EQPreset `json:"presets"`
+}
+
+// getEQ answers with the equalizer saved in the settings and its presets.
+func (s *Server) getEQ(w http.ResponseWriter, r *http.Request) {
+	current := s.settings.Get().Audio
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eqState{EQ: current.EQ, Presets: current.EQPresets})
+}
+
+// updateEQ applies the equalizer to the playback without interrupting it, and
+// saves it in the settings unless the "preview" query parameter is true.
+func (s *Server) updateEQ(w http.ResponseWriter, r *http.Request) {
+	var eq audio.EQ
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingsSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&eq); err != nil {
+		http.Error(w, "Invalid equalizer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	preview := false
+	if value := r.URL.Query().Get("preview"); value != "" {
+		var err error
+		if preview, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid preview, expected true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if preview {
+		if errs := settings.ValidateEQ("audio.eq", eq); len(errs) > 0 {
+			settingsError(w, &settings.ValidationError{Errors: errs})
+			return
+		}
+		s.audio.PreviewEQ(eq)
+	} else if err := s.saveEQ(eq); err != nil {
+		settingsError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eq)
+}
+
+// applyEQPreset applies the named equalizer preset and saves it in the settings.
+func (s *Server) applyEQPreset(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	for _, preset := range s.settings.Get().Audio.EQPresets {
+		if preset.Name != name {
+			continue
+		}
+
+		if err := s.saveEQ(preset.EQ); err != nil {
+			settingsError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preset.EQ)
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("Equalizer preset %q not found", name), http.StatusNotFound)
+}
+
+// saveEQ saves the equalizer in the settings, which applies it to the playback.
+func (s *Server) saveEQ(eq audio.EQ) error {
+	patch, err := json.Marshal(map[string]any{
+		"audio": map[string]any{"eq": eq},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.settings.Patch(patch)
+	return err
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+)
+
+// generatorsState lists the sound generators and the playing one.
+type generatorsState struct {
+	Generators []audio.Generator     `json:"generators"`
+	Playing    *audio.GeneratorState `json:"playing"` // Playing is the playing generator, nil if none.
+}
+
+// listGenerators answers with the sound generators and the playing one.
+func (s *Server) listGenerators(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(generatorsState{
+		Generators: audio.Generators(),
+		Playing:    s.audio.GetPlayerState().Generator,
+	})
+}
+
+// startGenerator plays the generator under the music. The optional body
+// overrides the volume and the timer, in minutes, of the settings.
+func (s *Server) startGenerator(w http.ResponseWriter, r *http.Request) {
+	var options audio.GeneratorOptions
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&options); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid generator options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kind := audio.GeneratorKind(chi.URLParam(r, "kind"))
+	if !s.isParent(r) {
+		if err := s.audio.CanPlay(kind.TrackID()); err != nil {
+			http.Error(w, err.Error(), trackErrorStatus(err))
+			return
+		}
+	}
+
+	state, err := s.audio.StartGenerator(kind, options)
+	if err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+func (s *Server) stopGenerator(w http.ResponseWriter, r *http.Request) {
+	s.audio.StopGenerator()
+	w.WriteHeader(http.StatusOK)
+}
+
+// setGeneratorVolume changes the volume of the playing generator to the
+// "value" query parameter, within the limits of the music volume.
+func (s *Server) setGeneratorVolume(w http.ResponseWriter, r *http.Request) {
+	volume, err := strconv.ParseFloat(r.URL.Query().Get("value"), 64)
+	if err != nil {
+		http.Error(w, "Query parameter 'value' must be a number", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.audio.SetGeneratorVolume(volume); err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
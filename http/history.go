@@ -0,0 +1,284 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/OhohLeo/hifi-baby/sql"
+)
+
+// maxHistorySize is the maximum size in bytes of an imported history.
+const maxHistorySize = 64 << 20
+
+// errInvalidHistory is returned when an imported history cannot be read.
+var errInvalidHistory = errors.New("invalid history")
+
+// historyColumns are the columns of the CSV history, in order.
+var historyColumns = []string{
+	"at", "track_id", "track_name", "fingerprint", "during", "source",
+	"end_reason", "start_position", "end_position", "track_duration", "profile_id",
+}
+
+// historyFormat returns the "format" query parameter, csv or json (the default).
+func historyFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		return "json", nil
+	case "csv":
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid format %q, expected csv or json", format)
+	}
+}
+
+// exportHistory streams the listened tracks between the optional "from" and
+// "to" bounds, as a CSV file or a JSON array.
+func (s *Server) exportHistory(w http.ResponseWriter, r *http.Request) {
+	format, err := historyFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, err := timeParam(r, "from", time.Local)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := timeParam(r, "to", time.Local)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="hifi-baby-history.`+format+`"`)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		err = exportHistoryCSV(w, s.database, from, to)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		err = exportHistoryJSON(w, s.database, from, to)
+	}
+	if err != nil {
+		// The response has already started: the client gets a truncated file
+		log.Error().Err(err).Msg("Failed to export history")
+	}
+}
+
+func exportHistoryCSV(w io.Writer, database *sql.Database, from, to time.Time) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(historyColumns); err != nil {
+		return err
+	}
+
+	err := database.ExportHistory(from, to, func(record *sql.HistoryRecord) error {
+		trackID, profileID := "", ""
+		if record.TrackID != uuid.Nil {
+			trackID = record.TrackID.String()
+		}
+		if record.ProfileID != uuid.Nil {
+			profileID = record.ProfileID.String()
+		}
+		return writer.Write([]string{
+			record.At.Format(time.RFC3339Nano),
+			trackID,
+			record.TrackName,
+			record.Fingerprint,
+			strconv.FormatInt(record.During, 10),
+			record.Source,
+			record.EndReason,
+			strconv.FormatInt(record.StartPosition, 10),
+			strconv.FormatInt(record.EndPosition, 10),
+			strconv.FormatInt(record.TrackDuration, 10),
+			profileID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func exportHistoryJSON(w io.Writer, database *sql.Database, from, to time.Time) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	separator := ""
+	err := database.ExportHistory(from, to, func(record *sql.HistoryRecord) error {
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		separator = ","
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// importHistory adds the listened tracks of a history exported as a CSV file
+// or a JSON array, skipping those already known.
+func (s *Server) importHistory(w http.ResponseWriter, r *http.Request) {
+	format, err := historyFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("format") == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		format = "csv"
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxHistorySize)
+
+	var next func() (*sql.HistoryRecord, error)
+	if format == "csv" {
+		next, err = historyCSVReader(body)
+	} else {
+		next, err = historyJSONReader(body)
+	}
+
+	var result *sql.ImportResult
+	if err == nil {
+		result, err = s.database.ImportHistory(next)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errInvalidHistory) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "Failed to import history: "+err.Error(), status)
+		return
+	}
+
+	log.Info().Msgf("History imported: %d listened tracks added, %d duplicates", result.Imported, result.Duplicates)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// historyJSONReader returns the function reading the records of a JSON array one by one.
+func historyJSONReader(r io.Reader) (func() (*sql.HistoryRecord, error), error) {
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, fmt.Errorf("%w: expected a JSON array", errInvalidHistory)
+	}
+
+	return func() (*sql.HistoryRecord, error) {
+		if !decoder.More() {
+			return nil, io.EOF
+		}
+
+		var record sql.HistoryRecord
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidHistory, err)
+		}
+		if err := checkHistoryRecord(&record); err != nil {
+			return nil, err
+		}
+		return &record, nil
+	}, nil
+}
+
+// historyCSVReader returns the function reading the records of a CSV file one
+// by one. The columns are identified by the header line and may be in any order.
+func historyCSVReader(r io.Reader) (func() (*sql.HistoryRecord, error), error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing CSV header: %w", errInvalidHistory, err)
+	}
+
+	columns := make(map[string]int)
+	for idx, name := range header {
+		columns[strings.TrimSpace(name)] = idx
+	}
+	for _, name := range []string{"at", "track_name"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing %q CSV column", errInvalidHistory, name)
+		}
+	}
+
+	return func() (*sql.HistoryRecord, error) {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidHistory, err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if idx, ok := columns[name]; ok {
+				return fields[idx]
+			}
+			return ""
+		}
+		number := func(name string) int64 {
+			if err != nil || field(name) == "" {
+				return 0
+			}
+			var n int64
+			n, err = strconv.ParseInt(field(name), 10, 64)
+			return n
+		}
+
+		record := &sql.HistoryRecord{
+			TrackName:     field("track_name"),
+			Fingerprint:   field("fingerprint"),
+			Source:        field("source"),
+			EndReason:     field("end_reason"),
+			During:        number("during"),
+			StartPosition: number("start_position"),
+			EndPosition:   number("end_position"),
+			TrackDuration: number("track_duration"),
+		}
+		if err == nil {
+			record.At, err = time.Parse(time.RFC3339Nano, field("at"))
+		}
+		if err == nil && field("track_id") != "" {
+			record.TrackID, err = uuid.Parse(field("track_id"))
+		}
+		if err == nil && field("profile_id") != "" {
+			record.ProfileID, err = uuid.Parse(field("profile_id"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", errInvalidHistory, line, err)
+		}
+
+		if err := checkHistoryRecord(record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		return record, nil
+	}, nil
+}
+
+// checkHistoryRecord checks the fields required to import a record.
+func checkHistoryRecord(record *sql.HistoryRecord) error {
+	if record.At.IsZero() {
+		return fmt.Errorf("%w: missing time", errInvalidHistory)
+	}
+	if record.TrackName == "" {
+		return fmt.Errorf("%w: missing track name", errInvalidHistory)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OhohLeo/hifi-baby/sql"
+)
+
+const (
+	defaultPageSize = 100  // defaultPageSize is the number of items of a page when no limit is given.
+	maxPageSize     = 1000 // maxPageSize is the maximum number of items of a page.
+)
+
+// limitParam reads the "limit" query parameter, the number of items of a page.
+func limitParam(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		return 0, fmt.Errorf("invalid limit %q, expected a number between 1 and %d", value, maxPageSize)
+	}
+	return limit, nil
+}
+
+// formatCursor returns the opaque form of the cursor given to the clients.
+func formatCursor(cursor sql.Cursor) string {
+	raw := cursor.At.Format(time.RFC3339Nano) + "," + strconv.FormatUint(uint64(cursor.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseCursor reads a cursor returned by formatCursor, the empty cursor being the first page.
+func parseCursor(value string) (sql.Cursor, error) {
+	var cursor sql.Cursor
+	if value == "" {
+		return cursor, nil
+	}
+
+	invalid := fmt.Errorf("invalid cursor %q", value)
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, invalid
+	}
+	at, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return cursor, invalid
+	}
+
+	if cursor.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+		return cursor, invalid
+	}
+	parsedID, err := strconv.ParseUint(id, 10, 0)
+	if err != nil {
+		return cursor, invalid
+	}
+	cursor.ID = uint(parsedID)
+
+	return cursor, nil
+}
+
+// setNextLink sets the Link header to the next page, the request with the given cursor.
+func setNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	next := *r.URL
+	query := next.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
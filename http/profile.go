@@ -0,0 +1,142 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+)
+
+// activeProfileRequest selects the active profile, none for the nil ID.
+type activeProfileRequest struct {
+	ID uuid.UUID `json:"id"`
+}
+
+// profileParam reads the optional "profile" query parameter, uuid.Nil if missing.
+func profileParam(r *http.Request) (uuid.UUID, error) {
+	value := r.URL.Query().Get("profile")
+	if value == "" {
+		return uuid.Nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid profile %q", value)
+	}
+	return id, nil
+}
+
+// decodeProfile reads and validates the profile sent in the request body.
+func decodeProfile(w http.ResponseWriter, r *http.Request) (*audio.Profile, bool) {
+	var profile audio.Profile
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingsSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profile); err != nil {
+		http.Error(w, "Invalid profile: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := profile.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &profile, true
+}
+
+func (s *Server) listProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := s.database.Profiles()
+	if err != nil {
+		http.Error(w, "Failed to get profiles", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(profiles)
+}
+
+func (s *Server) createProfile(w http.ResponseWriter, r *http.Request) {
+	profile, ok := decodeProfile(w, r)
+	if !ok {
+		return
+	}
+
+	profile.ID = uuid.Nil
+	if err := s.database.SaveProfile(profile); err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(profile)
+}
+
+// updateProfile replaces the profile, applied at once if it is the active one.
+func (s *Server) updateProfile(w http.ResponseWriter, r *http.Request) {
+	profileID, err := uuid.Parse(chi.URLParam(r, "profileID"))
+	if err != nil {
+		http.Error(w, "Invalid profile id", http.StatusBadRequest)
+		return
+	}
+
+	profile, ok := decodeProfile(w, r)
+	if !ok {
+		return
+	}
+
+	profile.ID = profileID
+	if err := s.database.SaveProfile(profile); err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+	s.audio.RefreshProfile(profile)
+
+	json.NewEncoder(w).Encode(profile)
+}
+
+// deleteProfile deletes the profile, deactivated first if it is the active one.
+func (s *Server) deleteProfile(w http.ResponseWriter, r *http.Request) {
+	profileID, err := uuid.Parse(chi.URLParam(r, "profileID"))
+	if err != nil {
+		http.Error(w, "Invalid profile id", http.StatusBadRequest)
+		return
+	}
+
+	if active := s.audio.Profile(); active != nil && active.ID == profileID {
+		if _, err := s.audio.ActivateProfile(uuid.Nil); err != nil {
+			http.Error(w, err.Error(), trackErrorStatus(err))
+			return
+		}
+	}
+
+	if err := s.database.DeleteProfile(profileID); err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// activeProfile answers with the active profile, null if none.
+func (s *Server) activeProfile(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(s.audio.Profile())
+}
+
+// activateProfile switches the active profile, sent as {"id": "..."}, or
+// deactivates the profiles for a null ID.
+func (s *Server) activateProfile(w http.ResponseWriter, r *http.Request) {
+	var request activeProfileRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingsSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, "Invalid profile: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile, err := s.audio.ActivateProfile(request.ID)
+	if err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(profile)
+}
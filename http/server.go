@@ -1,7 +1,12 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http" // Ensure os is imported
 	"strconv"
 	"strings"
@@ -14,38 +19,46 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/OhohLeo/hifi-baby/audio"
+	"github.com/OhohLeo/hifi-baby/backup"
 	"github.com/OhohLeo/hifi-baby/settings"
 	"github.com/OhohLeo/hifi-baby/sql"
 )
 
+// maxSettingsSize is the maximum size in bytes of the settings sent by the client.
+const maxSettingsSize = 1 << 20
+
 type Config struct {
 	ServerURL    string `env:"SERVER_URL,default=localhost:3000"`
 	ServerUIPath string `env:"SERVER_UI_PATH,default=dist"`
+	ParentToken  string `env:"PARENT_TOKEN"` // ParentToken protects the parent-only routes, disabled if empty.
 }
 
 type Server struct {
 	audio     *audio.Audio
 	router    *chi.Mux
 	serverURL string // Use ServerURL to start the server
+	http      *http.Server
 	config    Config
-	settings  *settings.Settings
+	settings  *settings.Store
 	database  *sql.Database
+	backups   *backup.Manager
 }
 
 // NewServer creates a new Server instance with routes configured for audio management.
 func NewServer(
 	audio *audio.Audio,
 	config Config,
-	settings *settings.Settings,
+	settings *settings.Store,
 	database *sql.Database,
+	backups *backup.Manager,
 ) *Server {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},                            // Accept requests from all origins
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"}, // Specify allowed methods
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedOrigins:   []string{"*"},                                     // Accept requests from all origins
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"}, // Specify allowed methods
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Range"},
+		ExposedHeaders:   []string{"Link", "X-Total-Count", "Accept-Ranges", "Content-Range", "Content-Length", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value of the Access-Control-Max-Age header.
 	}))
@@ -60,26 +73,97 @@ func NewServer(
 		config:    config,
 		settings:  settings,
 		database:  database,
+		backups:   backups,
+	}
+	server.http = &http.Server{Addr: config.ServerURL, Handler: r}
+
+	if config.ParentToken == "" {
+		log.Warn().Msg("No parent token configured: parent-only routes are not protected")
 	}
 
 	r.Route("/audio", func(r chi.Router) {
-		r.Post("/", server.addTrack)                              // Add a track
-		r.Delete("/{trackID}", server.removeTrack)                // Remove a track
-		r.Post("/play/{trackID}", server.playTrack)               // Play a track
-		r.Post("/pause", server.pauseTrack)                       // Pause the current track
-		r.Post("/resume", server.resumeTrack)                     // Resume the current track
-		r.Post("/stop", server.stopTrack)                         // Stop the current track
-		r.Get("/tracks", server.listTracks)                       // List all tracks
-		r.Get("/tracks/listened", server.listenedTracks)          // List all listened tracks
-		r.Get("/tracks/most-listened", server.mostListenedTracks) // Get the most listened tracks
-		r.Get("/state", server.currentPlayerState)                // Get the current player state
-		r.Post("/volume/up", server.increaseVolume)               // Increase volume
-		r.Post("/volume/down", server.decreaseVolume)             // Decrease volume
-		r.Post("/volume/mute", server.muteVolume)                 // Mute volume
+		r.Post("/", server.addTrack)                           // Add a track
+		r.Post("/import", server.importArchive)                // Import tracks from an archive
+		r.Delete("/{trackID}", server.removeTrack)             // Remove a track
+		r.Post("/play/{trackID}", server.playTrack)            // Play a track
+		r.Post("/pause", server.pauseTrack)                    // Pause the current track
+		r.Post("/resume", server.resumeTrack)                  // Resume the current track
+		r.Post("/stop", server.stopTrack)                      // Stop the current track
+		r.Get("/tracks", server.listTracks)                    // Search, filter, sort and paginate the tracks
+		r.Put("/tracks/{trackID}/rating", server.rateTrack)    // Rate a track or mark it as a favourite
+		r.Post("/favourite", server.toggleFavourite)           // Mark the current track as a favourite, or unmark it
+		r.Get("/state", server.currentPlayerState)             // Get the current player state
+		r.Post("/volume/up", server.increaseVolume)            // Increase volume
+		r.Post("/volume/down", server.decreaseVolume)          // Decrease volume
+		r.Post("/volume/mute", server.muteVolume)              // Mute volume
+		r.Post("/night", server.setNightMode)                  // Enable or disable the night mode
+		r.Put("/loop", server.setLoop)                         // Repeat the track, the collection or a section of the track
+		r.Get("/eq", server.getEQ)                             // Get the equalizer and its presets
+		r.Put("/eq", server.updateEQ)                          // Adjust the equalizer during the playback
+		r.Post("/eq/presets/{name}", server.applyEQPreset)     // Apply an equalizer preset
+		r.Get("/generators", server.listGenerators)            // List the sound generators and the playing one
+		r.Post("/generators/stop", server.stopGenerator)       // Stop the playing sound generator
+		r.Put("/generators/volume", server.setGeneratorVolume) // Change the volume of the playing sound generator
+		r.Post("/generators/{kind}", server.startGenerator)    // Play a sound generator under the music
+
+		// Parent-only routes
+		r.Group(func(r chi.Router) {
+			r.Use(server.requireParent)
+			r.Get("/tracks/{trackID}/file", server.downloadTrack)                // Download or stream a track file
+			r.Put("/tracks/{trackID}/availability", server.setTrackAvailability) // Hide a track or disable it for a while
+			r.Get("/tracks/listened", server.listenedTracks)                     // List the listened tracks, page by page
+			r.Get("/tracks/listened/daily", server.dailyListens)                 // List the listens rolled up per day
+			r.Get("/tracks/most-listened", server.mostListenedTracks)            // Get the most listened tracks
+		})
+	})
+
+	// Parent-only routes
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(server.requireParent)
+		r.Get("/backup", server.backup)    // Download a backup of the database, settings and tracks
+		r.Post("/restore", server.restore) // Restore a backup
+	})
+
+	r.Route("/profiles", func(r chi.Router) {
+		r.Get("/active", server.activeProfile) // Get the active profile
+
+		// Parent-only routes
+		r.Group(func(r chi.Router) {
+			r.Use(server.requireParent)
+			r.Put("/active", server.activateProfile)       // Switch the active profile
+			r.Get("/", server.listProfiles)                // List the profiles
+			r.Post("/", server.createProfile)              // Create a profile
+			r.Put("/{profileID}", server.updateProfile)    // Update a profile
+			r.Delete("/{profileID}", server.deleteProfile) // Delete a profile
+		})
+	})
+
+	// Parent-only routes
+	r.Route("/history", func(r chi.Router) {
+		r.Use(server.requireParent)
+		r.Get("/export", server.exportHistory)  // Export the listening history as CSV or JSON
+		r.Post("/import", server.importHistory) // Import a listening history exported as CSV or JSON
+	})
+
+	// Parent-only routes
+	r.Route("/stats", func(r chi.Router) {
+		r.Use(server.requireParent)
+		r.Get("/daily", stats(database.DailyStats))     // Listening time per day
+		r.Get("/heatmap", stats(database.HeatmapStats)) // Listening time per weekday and hour
+		r.Get("/tracks", stats(database.TrackStats))    // Listening trend per track
+		r.Get("/summary", stats(database.SummaryStats)) // Sessions and skip rate
 	})
 
 	r.Get("/settings", server.getSettings)
-	r.Put("/settings", server.updateSettings)
+	r.Get("/settings/history", server.settingsHistory)
+
+	// Parent-only routes
+	r.Group(func(r chi.Router) {
+		r.Use(server.requireParent)
+		r.Put("/settings", server.updateSettings)
+		r.Patch("/settings", server.patchSettings)
+		r.Post("/settings/history/{versionID}/restore", server.restoreSettings)
+	})
 
 	return server
 }
@@ -105,30 +189,20 @@ func FileServer(r chi.Router, path string, root string) {
 	})
 }
 
-// Example of a method to start the HTTP server using the Router in Server
+// Run starts the HTTP server and blocks until it fails or is shut down.
 func (s *Server) Run() error {
 	log.Info().Msgf("Starting server on %s", s.serverURL)
-	return http.ListenAndServe(s.serverURL, s.router) // Use ServerURL to start the server
-}
-
-func (s *Server) addTrack(w http.ResponseWriter, r *http.Request) {
-	// Handle file upload
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "Invalid file upload: "+err.Error(), http.StatusBadRequest)
-		return
+	if err := s.http.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
-	defer file.Close()
+	return nil
+}
 
-	// Add the track to the audio manager
-	track, err := s.audio.AddTrack(file, header)
-	if err != nil {
-		http.Error(w, "Failed to add the track", http.StatusInternalServerError)
-		return
-	}
-	// Respond to the client
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(track)
+// Shutdown stops accepting connections and waits for the active requests
+// to complete, until the context is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Info().Msg("Shutting down server")
+	return s.http.Shutdown(ctx)
 }
 
 func (s *Server) removeTrack(w http.ResponseWriter, r *http.Request) {
@@ -139,7 +213,7 @@ func (s *Server) removeTrack(w http.ResponseWriter, r *http.Request) {
 	}
 	err = s.audio.RemoveTrack(trackID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), trackErrorStatus(err))
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -152,7 +226,19 @@ func (s *Server) playTrack(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.audio.PlayTrack(trackID)
+	// Only the parents may choose a hidden or disabled track, or bypass the
+	// restrictions of the active profile
+	if !s.isParent(r) {
+		if err := s.audio.CanPlay(trackID); err != nil {
+			http.Error(w, err.Error(), trackErrorStatus(err))
+			return
+		}
+	}
+
+	if err := s.audio.PlayTrack(trackID, audio.SourceHTTP); err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -167,12 +253,104 @@ func (s *Server) resumeTrack(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) stopTrack(w http.ResponseWriter, r *http.Request) {
-	s.audio.Stop()
+	s.audio.Stop(audio.EndStopped)
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *Server) listTracks(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(s.audio.Tracks())
+// rateTrack sets the rating of a track, sent as {"favourite": true, "rating": 4}.
+func (s *Server) rateTrack(w http.ResponseWriter, r *http.Request) {
+	trackID, err := uuid.Parse(chi.URLParam(r, "trackID"))
+	if err != nil {
+		http.Error(w, "Invalid track id", http.StatusBadRequest)
+		return
+	}
+
+	var rating audio.Rating
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingsSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rating); err != nil {
+		http.Error(w, "Invalid rating: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	track, err := s.audio.RateTrack(trackID, rating)
+	if err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(track)
+}
+
+// setTrackAvailability hides a track or disables it, sent as
+// {"hidden": false, "disabled_until": "2024-06-01T00:00:00Z", "disabled_windows": [{"from": "19:00", "to": "07:00"}]}.
+func (s *Server) setTrackAvailability(w http.ResponseWriter, r *http.Request) {
+	trackID, err := uuid.Parse(chi.URLParam(r, "trackID"))
+	if err != nil {
+		http.Error(w, "Invalid track id", http.StatusBadRequest)
+		return
+	}
+
+	var availability audio.Availability
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingsSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&availability); err != nil {
+		http.Error(w, "Invalid availability: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	track, err := s.audio.SetAvailability(trackID, availability)
+	if err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(track)
+}
+
+// toggleFavourite marks the playing track as a favourite, or unmarks it.
+func (s *Server) toggleFavourite(w http.ResponseWriter, r *http.Request) {
+	track, err := s.audio.ToggleFavourite()
+	if err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(track)
+}
+
+// downloadTrack serves the track file with Range, ETag and Last-Modified support,
+// so that it can be previewed in a browser without playing on the speaker.
+func (s *Server) downloadTrack(w http.ResponseWriter, r *http.Request) {
+	trackID, err := uuid.Parse(chi.URLParam(r, "trackID"))
+	if err != nil {
+		http.Error(w, "Invalid track id", http.StatusBadRequest)
+		return
+	}
+
+	track, ok := s.audio.GetTrack(trackID)
+	if !ok {
+		http.Error(w, "Track not found", http.StatusNotFound)
+		return
+	}
+
+	file, err := track.Open()
+	if err != nil {
+		http.Error(w, "Failed to open the track", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Failed to open the track", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", track.ContentType())
+	w.Header().Set("ETag", fmt.Sprintf("%q", track.Fingerprint))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": track.Name}))
+	http.ServeContent(w, r, track.Name, info.ModTime(), file)
 }
 
 func (s *Server) listenedTracks(w http.ResponseWriter, r *http.Request) {
@@ -183,15 +361,66 @@ func (s *Server) listenedTracks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listenedTracks, err := s.database.ListenedTracks(sinceTime)
+	limit, err := limitParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cursor, err := parseCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profileID, err := profileParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// One more listened track tells whether there is a next page
+	listenedTracks, err := s.database.ListenedTracks(profileID, sinceTime, cursor, limit+1)
 	if err != nil {
 		http.Error(w, "Failed to get listened tracks", http.StatusInternalServerError)
 		return
 	}
 
+	if len(listenedTracks) > limit {
+		listenedTracks = listenedTracks[:limit]
+		last := listenedTracks[limit-1]
+		setNextLink(w, r, formatCursor(sql.Cursor{At: last.At, ID: last.ID}))
+	}
+
 	json.NewEncoder(w).Encode(listenedTracks)
 }
 
+// dailyListens lists the listens rolled up by the retention policy, per day
+// and track, between the "from" and "to" dates, of the optional "profile".
+func (s *Server) dailyListens(w http.ResponseWriter, r *http.Request) {
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			http.Error(w, "Invalid from or to date, expected 2006-01-02", http.StatusBadRequest)
+			return
+		}
+	}
+
+	profileID, err := profileParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dailyListens, err := s.database.DailyListens(profileID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to get daily listens", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(dailyListens)
+}
+
 func (s *Server) mostListenedTracks(w http.ResponseWriter, r *http.Request) {
 	since := r.URL.Query().Get("since")
 	sinceTime, err := time.Parse(time.RFC3339, since)
@@ -207,7 +436,13 @@ func (s *Server) mostListenedTracks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mostListenedTracks, err := s.database.MostListenedTracks(sinceTime, topNbInt)
+	profileID, err := profileParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mostListenedTracks, err := s.database.MostListenedTracks(profileID, sinceTime, topNbInt)
 	if err != nil {
 		http.Error(w, "Failed to get listened tracks", http.StatusInternalServerError)
 		return
@@ -240,30 +475,128 @@ func (s *Server) muteVolume(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// setLoop changes the loop mode, such as {"mode": "section", "start": 30, "end": 45.5}
+// to repeat the section of the playing track between 30 and 45.5 seconds.
+func (s *Server) setLoop(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Mode  audio.LoopMode `json:"mode"`
+		Start float64        `json:"start"`
+		End   float64        `json:"end"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		http.Error(w, "Invalid loop: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	loop := audio.Loop{Mode: body.Mode}
+	if body.Mode == audio.LoopSection {
+		loop.Section = &audio.Section{Start: body.Start, End: body.End}
+	}
+	if err := s.audio.SetLoop(loop); err != nil {
+		http.Error(w, err.Error(), trackErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.audio.GetPlayerState().Loop)
+}
+
+// setNightMode enables or disables the night mode until the next start or end
+// of its schedule.
+func (s *Server) setNightMode(w http.ResponseWriter, r *http.Request) {
+	enableParam := r.URL.Query().Get("enable")
+	if enableParam != "true" && enableParam != "false" {
+		http.Error(w, "Query parameter 'enable' must be 'true' or 'false'", http.StatusBadRequest)
+		return
+	}
+	s.audio.SetNightMode(enableParam == "true")
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *Server) getSettings(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Encode the settings to the response
-	if err := json.NewEncoder(w).Encode(s.settings); err != nil {
+	if err := json.NewEncoder(w).Encode(s.settings.Get()); err != nil {
 		http.Error(w, "Failed to encode settings: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
 }
 
+// updateSettings replaces the settings, which are applied immediately.
 func (s *Server) updateSettings(w http.ResponseWriter, r *http.Request) {
-	var newSettings settings.Settings
+	newSettings, err := settings.Decode(http.MaxBytesReader(w, r.Body, maxSettingsSize))
+	if err == nil {
+		newSettings, err = s.settings.Update(newSettings)
+	}
+	if err != nil {
+		settingsError(w, err)
+		return
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
-		http.Error(w, "Failed to decode settings: "+err.Error(), http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSettings)
+}
+
+// patchSettings updates some of the settings with a JSON merge patch (RFC 7396).
+func (s *Server) patchSettings(w http.ResponseWriter, r *http.Request) {
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSettingsSize))
+	if err != nil {
+		http.Error(w, "Failed to read settings: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Encode the updated configuration back to JSON
-	if err := s.settings.Update(&newSettings); err != nil {
-		http.Error(w, "Failed to update settings: "+err.Error(), http.StatusInternalServerError)
+	newSettings, err := s.settings.Patch(patch)
+	if err != nil {
+		settingsError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSettings)
+}
+
+// settingsHistory lists the saved versions of the settings, most recent first.
+func (s *Server) settingsHistory(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.settings.History()
+	if err != nil {
+		http.Error(w, "Failed to read settings history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// restoreSettings restores a saved version of the settings.
+func (s *Server) restoreSettings(w http.ResponseWriter, r *http.Request) {
+	restored, err := s.settings.Restore(chi.URLParam(r, "versionID"))
+	if errors.Is(err, settings.ErrVersionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		settingsError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restored)
+}
+
+// settingsError answers with the field errors of invalid settings.
+func settingsError(w http.ResponseWriter, err error) {
+	var validationErr *settings.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(validationErr)
+	case errors.Is(err, settings.ErrInvalidSettings):
+		http.Error(w, "Failed to decode settings: "+err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to update settings: "+err.Error(), http.StatusInternalServerError)
+	}
 }
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/OhohLeo/hifi-baby/sql"
+)
+
+// defaultStatsDays is the number of days of the statistics when no range is given.
+const defaultStatsDays = 7
+
+// statsRange reads the "from" and "to" bounds, as RFC 3339 times or dates,
+// the "tz" time zone of the buckets, such as "Europe/Paris", and the "profile".
+// By default, the range covers the last 7 days in the local time zone.
+func statsRange(r *http.Request) (sql.StatsRange, error) {
+	query := r.URL.Query()
+	statsRange := sql.StatsRange{Location: time.Local}
+
+	if tz := query.Get("tz"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return statsRange, fmt.Errorf("invalid tz %q", tz)
+		}
+		statsRange.Location = location
+	}
+
+	parse := func(name string, defaultValue time.Time) (time.Time, error) {
+		if query.Get(name) == "" {
+			return defaultValue, nil
+		}
+		return timeParam(r, name, statsRange.Location)
+	}
+
+	var err error
+	if statsRange.Profile, err = profileParam(r); err != nil {
+		return statsRange, err
+	}
+
+	now := time.Now().In(statsRange.Location)
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, statsRange.Location)
+	if statsRange.To, err = parse("to", tomorrow); err != nil {
+		return statsRange, err
+	}
+	if statsRange.From, err = parse("from", statsRange.To.AddDate(0, 0, -defaultStatsDays)); err != nil {
+		return statsRange, err
+	}
+	if !statsRange.From.Before(statsRange.To) {
+		return statsRange, fmt.Errorf("from must be before to")
+	}
+
+	return statsRange, nil
+}
+
+// timeParam reads a query parameter given as a RFC 3339 time or as a date in
+// the location. It returns the zero time if the parameter is missing.
+func timeParam(r *http.Request, name string, location *time.Location) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, location)
+	if err != nil {
+		return t, fmt.Errorf("invalid %s %q, expected a RFC 3339 time or a date", name, value)
+	}
+	return t, nil
+}
+
+// stats answers with the statistics computed over the requested range.
+func stats[T any](compute func(sql.StatsRange) (T, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statsRange, err := statsRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := compute(statsRange)
+		if err != nil {
+			http.Error(w, "Failed to compute statistics: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,236 @@
+package http
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+)
+
+// trackKey is the position of a track in a sorted track list.
+type trackKey struct {
+	value int64     // value is the sorted value, 0 when sorted by name.
+	name  string    // name is the folded track name, ordering the tracks of equal values.
+	id    uuid.UUID // id orders the tracks of equal names.
+}
+
+// compare orders the keys by value, then name, then ID.
+func (k trackKey) compare(other trackKey) int {
+	if c := cmp.Compare(k.value, other.value); c != 0 {
+		return c
+	}
+	if c := strings.Compare(k.name, other.name); c != 0 {
+		return c
+	}
+	return bytes.Compare(k.id[:], other.id[:])
+}
+
+// formatTrackCursor returns the opaque form of the key given to the clients.
+func formatTrackCursor(key trackKey) string {
+	raw := strconv.FormatInt(key.value, 10) + "," + key.id.String() + "," + key.name
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseTrackCursor reads a cursor returned by formatTrackCursor.
+func parseTrackCursor(value string) (trackKey, error) {
+	var key trackKey
+	invalid := fmt.Errorf("invalid cursor %q", value)
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return key, invalid
+	}
+	fields := strings.SplitN(string(raw), ",", 3)
+	if len(fields) != 3 {
+		return key, invalid
+	}
+
+	if key.value, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return key, invalid
+	}
+	if key.id, err = uuid.Parse(fields[1]); err != nil {
+		return key, invalid
+	}
+	key.name = fields[2]
+
+	return key, nil
+}
+
+// trackFilter reads the filter of the track list from the query parameters.
+func trackFilter(r *http.Request) (*audio.TrackFilter, error) {
+	query := r.URL.Query()
+	filter := &audio.TrackFilter{
+		Search:     query.Get("q"),
+		Collection: query.Get("collection"),
+	}
+
+	for _, formats := range query["format"] {
+		for _, format := range strings.Split(formats, ",") {
+			if format = strings.TrimSpace(format); format != "" {
+				filter.Formats = append(filter.Formats, format)
+			}
+		}
+	}
+
+	if value := query.Get("favourite"); value != "" {
+		favourite, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid favourite, expected true or false")
+		}
+		filter.Favourite = &favourite
+	}
+
+	for name, bound := range map[string]*int64{
+		"min_duration": &filter.MinDuration,
+		"max_duration": &filter.MaxDuration,
+	} {
+		if value := query.Get(name); value != "" {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds < 0 {
+				return nil, fmt.Errorf("invalid %s %q, expected a number of seconds", name, value)
+			}
+			*bound = seconds
+		}
+	}
+
+	return filter, nil
+}
+
+// trackKeys returns the function giving the key of a track for the "sort" query
+// parameter: name (the default), added, plays or duration.
+func (s *Server) trackKeys(r *http.Request) (func(track *audio.Track) trackKey, error) {
+	key := func(track *audio.Track, value int64) trackKey {
+		return trackKey{value: value, name: audio.Fold(track.Name), id: track.ID}
+	}
+
+	switch sortBy := r.URL.Query().Get("sort"); sortBy {
+	case "", "name":
+		return func(track *audio.Track) trackKey {
+			return key(track, 0)
+		}, nil
+	case "added":
+		return func(track *audio.Track) trackKey {
+			return key(track, track.AddedAt.UnixNano())
+		}, nil
+	case "duration":
+		return func(track *audio.Track) trackKey {
+			return key(track, track.Duration)
+		}, nil
+	case "plays":
+		counts, err := s.database.PlayCounts()
+		if err != nil {
+			return nil, err
+		}
+		return func(track *audio.Track) trackKey {
+			return key(track, int64(counts[track.ID]))
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid sort %q, expected name, added, plays or duration", sortBy)
+	}
+}
+
+// listTracks searches, filters and sorts the tracks. Hidden tracks, and those
+// outside the library of the active profile, are only listed to the parents.
+// The total number of matching tracks is sent in the X-Total-Count header.
+// The tracks are paginated when a "limit" or a "cursor" is given, the Link
+// header pointing to the next page.
+func (s *Server) listTracks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := trackFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	descending := false
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		http.Error(w, fmt.Sprintf("invalid order %q, expected asc or desc", order), http.StatusBadRequest)
+		return
+	}
+
+	keyOf, err := s.trackKeys(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var profile *audio.Profile
+	parent := s.isParent(r)
+	if !parent {
+		profile = s.audio.Profile()
+	}
+
+	type keyedTrack struct {
+		track *audio.Track
+		key   trackKey
+	}
+	var tracks []keyedTrack
+	for _, track := range s.audio.Tracks() {
+		if !parent && (track.Hidden || !profile.Allows(track)) {
+			continue
+		}
+		if filter.Match(track) {
+			tracks = append(tracks, keyedTrack{track: track, key: keyOf(track)})
+		}
+	}
+
+	// The order is reversed as a whole, so that the cursors stay consistent
+	compare := func(a, b trackKey) int {
+		if descending {
+			return b.compare(a)
+		}
+		return a.compare(b)
+	}
+	sort.Slice(tracks, func(i, j int) bool {
+		return compare(tracks[i].key, tracks[j].key) < 0
+	})
+
+	total := len(tracks)
+	if query.Has("limit") || query.Has("cursor") {
+		limit, err := limitParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if value := query.Get("cursor"); value != "" {
+			after, err := parseTrackCursor(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			start := sort.Search(len(tracks), func(i int) bool {
+				return compare(tracks[i].key, after) > 0
+			})
+			tracks = tracks[start:]
+		}
+
+		if len(tracks) > limit {
+			tracks = tracks[:limit]
+			setNextLink(w, r, formatTrackCursor(tracks[limit-1].key))
+		}
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	page := make([]*audio.Track, 0, len(tracks))
+	for _, track := range tracks {
+		page = append(page, track.track)
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
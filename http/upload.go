@@ -0,0 +1,273 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+)
+
+// ndjsonContentType is used to stream upload progress events.
+const ndjsonContentType = "application/x-ndjson"
+
+// progressInterval is the number of bytes received between two progress events.
+const progressInterval = 1 << 20
+
+// uploadEvent is a line of the upload progress stream.
+type uploadEvent struct {
+	Event string       `json:"event"`           // Event is either "progress" or "result".
+	File  string       `json:"file"`            // File is the uploaded file name as sent by the client.
+	Bytes int64        `json:"bytes,omitempty"` // Bytes is the number of bytes received so far.
+	Track *audio.Track `json:"track,omitempty"` // Track is the added track on success.
+	Error string       `json:"error,omitempty"` // Error describes why the file was refused.
+}
+
+// uploadResult is the outcome of a single uploaded file.
+type uploadResult struct {
+	File  string       `json:"file"`
+	Track *audio.Track `json:"track,omitempty"`
+	Error string       `json:"error,omitempty"`
+
+	status int
+}
+
+// progressReader reports the number of bytes read every progressInterval bytes.
+type progressReader struct {
+	reader   io.Reader
+	read     int64
+	reported int64
+	report   func(read int64)
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.reader.Read(buf)
+	p.read += int64(n)
+	if p.read-p.reported >= progressInterval {
+		p.reported = p.read
+		p.report(p.read)
+	}
+	return n, err
+}
+
+// addTrack handles the upload of one or several tracks as a streamed multipart form.
+// A single upload answers with the created track. Several uploads answer with
+// per-file results, and "Accept: application/x-ndjson" streams progress events.
+// Tracks are stored in the "collection" query parameter; folder uploads keep
+// the relative directories of the file names and may include cover images.
+func (s *Server) addTrack(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Invalid file upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var emit func(event uploadEvent)
+	if strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		emit = func(event uploadEvent) {
+			encoder.Encode(event)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	var results []*uploadResult
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result := &uploadResult{
+				Error:  "Invalid file upload: " + err.Error(),
+				status: http.StatusBadRequest,
+			}
+			results = append(results, result)
+			if emit != nil {
+				emit(uploadEvent{Event: "result", Error: result.Error})
+			}
+			break
+		}
+
+		// Ignore the non-file form fields
+		name := partFileName(part)
+		if name == "" {
+			part.Close()
+			continue
+		}
+
+		result := s.uploadFile(collection, name, part, emit)
+		part.Close()
+		results = append(results, result)
+
+		if emit != nil {
+			emit(uploadEvent{
+				Event: "result",
+				File:  result.File,
+				Track: result.Track,
+				Error: result.Error,
+			})
+		}
+	}
+
+	// Progress and results have already been streamed
+	if emit != nil {
+		return
+	}
+
+	switch len(results) {
+	case 0:
+		http.Error(w, "Invalid file upload: no file", http.StatusBadRequest)
+	case 1:
+		if results[0].Error != "" {
+			http.Error(w, results[0].Error, results[0].status)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(results[0].Track)
+	default:
+		status := http.StatusCreated
+		for _, result := range results {
+			if result.Error != "" {
+				status = http.StatusMultiStatus
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// uploadFile adds the given uploaded track or cover image to the audio manager.
+func (s *Server) uploadFile(collection, name string, src io.Reader, emit func(event uploadEvent)) *uploadResult {
+	if emit != nil {
+		src = &progressReader{
+			reader: src,
+			report: func(read int64) {
+				emit(uploadEvent{Event: "progress", File: name, Bytes: read})
+			},
+		}
+	}
+
+	// Folder uploads send the file path relative to the selected folder
+	dir, fileName := path.Split(strings.ReplaceAll(name, "\\", "/"))
+	collection = path.Join(collection, dir)
+
+	if audio.IsCoverFormat(path.Ext(fileName)) {
+		if _, err := s.audio.AddCover(collection, fileName, src); err != nil {
+			log.Error().Err(err).Msgf("Failed to add the cover %q", name)
+			return &uploadResult{
+				File:   name,
+				Error:  "Failed to add the cover: " + err.Error(),
+				status: trackErrorStatus(err),
+			}
+		}
+		return &uploadResult{File: name, status: http.StatusCreated}
+	}
+
+	track, err := s.audio.AddTrack(collection, fileName, src)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to add the track %q", name)
+		return &uploadResult{
+			File:   name,
+			Error:  "Failed to add the track: " + err.Error(),
+			status: trackErrorStatus(err),
+		}
+	}
+
+	return &uploadResult{File: name, Track: track, status: http.StatusCreated}
+}
+
+// importArchive extracts a .zip or .tar.gz archive, sent either as the request
+// body or as the "archive" multipart file, into the "collection" query parameter.
+func (s *Server) importArchive(w http.ResponseWriter, r *http.Request) {
+	var src io.Reader = r.Body
+
+	if reader, err := r.MultipartReader(); err == nil {
+		src = nil
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() == "archive" {
+				src = part
+				break
+			}
+			part.Close()
+		}
+	}
+
+	if src == nil {
+		http.Error(w, "Invalid archive upload: missing 'archive' file", http.StatusBadRequest)
+		return
+	}
+
+	report, err := s.audio.Import(src, r.URL.Query().Get("collection"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to import archive")
+		http.Error(w, "Failed to import archive: "+err.Error(), trackErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// partFileName returns the file name of the part as sent by the client.
+// Unlike multipart.Part.FileName, it keeps the relative directories of folder uploads.
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return part.FileName()
+	}
+	return params["filename"]
+}
+
+// trackErrorStatus returns the HTTP status matching an error of the audio manager.
+func trackErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, audio.ErrFileTooLarge),
+		errors.Is(err, audio.ErrImportTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, audio.ErrInvalidFileName),
+		errors.Is(err, audio.ErrUnsupportedFormat),
+		errors.Is(err, audio.ErrUnsupportedArchive),
+		errors.Is(err, audio.ErrInvalidAudioStream),
+		errors.Is(err, audio.ErrInvalidImage),
+		errors.Is(err, audio.ErrInvalidRating),
+		errors.Is(err, audio.ErrInvalidAvailability),
+		errors.Is(err, audio.ErrInvalidProfile),
+		errors.Is(err, audio.ErrInvalidGenerator),
+		errors.Is(err, audio.ErrInvalidLoop):
+		return http.StatusBadRequest
+	case errors.Is(err, audio.ErrTrackUnavailable):
+		return http.StatusConflict
+	case errors.Is(err, audio.ErrOutsideSchedule),
+		errors.Is(err, audio.ErrQuotaReached):
+		return http.StatusForbidden
+	case errors.Is(err, audio.ErrTrackNotFound),
+		errors.Is(err, audio.ErrNoTrack),
+		errors.Is(err, audio.ErrProfileNotFound),
+		errors.Is(err, audio.ErrGeneratorNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, audio.ErrClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,6 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
@@ -9,16 +16,26 @@ import (
 )
 
 func main() {
-	cfg, err := app.NewConfig()
+	cfg, err := app.NewConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatal().Msgf("Erreur lors de l'initialisation de la configuration : %v", err)
 	}
 
-	settings, err := settings.NewSettings(cfg.SettingsPath)
+	settings, err := settings.NewStore(cfg.Settings, cfg.Overrides)
 	if err != nil {
 		log.Fatal().Msgf("Erreur lors de l'initialisation du stockage : %v", err)
 	}
 
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout, settings); err != nil {
+			log.Fatal().Msgf("Erreur lors de l'affichage de la configuration : %v", err)
+		}
+		return
+	}
+
 	level, errLevel := zerolog.ParseLevel(cfg.LogLevel)
 	if errLevel != nil {
 		log.Fatal().Msgf("Erreur lors de la définition du niveau de log %q : %v", cfg.LogLevel, errLevel)
@@ -30,7 +47,11 @@ func main() {
 		log.Fatal().Msgf("Erreur lors de l'initialisation de l'application : %v", errApp)
 	}
 
-	if err := app.Run(); err != nil {
+	// Stop gracefully on Ctrl-C and on systemctl stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := app.Run(ctx); err != nil {
 		log.Fatal().Msgf("Erreur lors de l'exécution de l'application : %v", err)
 	}
 }
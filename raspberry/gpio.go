@@ -1,6 +1,7 @@
 package raspberry
 
 import (
+	"context"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -12,6 +13,7 @@ type Gpio struct {
 	offset         int
 	line           *gpiocdev.Line
 	lastEventsTime []time.Time
+	pressTime      time.Time // pressTime is when the button was pressed, zero once released.
 }
 
 // NewGpio creates a new Gpio instance
@@ -23,13 +25,29 @@ func NewGpio(chip string, offset int) *Gpio {
 }
 
 const (
-	StopMusic   = "stop"
-	ChangeMusic = "change"
+	StopMusic      = "stop"
+	ChangeMusic    = "change"
+	FavouriteMusic = "favourite"
+	NextProfile    = "profile"
 )
 
-func (g *Gpio) Listen(musicControl chan string) error {
+const (
+	longPressDuration     = 1500 * time.Millisecond // longPressDuration is how long the button is held to mark the track as a favourite, or to toggle the night mode.
+	veryLongPressDuration = 4 * time.Second         // veryLongPressDuration is how long the button is held to switch the profile.
+)
+
+// Listen sends the button actions to musicControl until the context is done,
+// then releases the GPIO line.
+func (g *Gpio) Listen(ctx context.Context, musicControl chan<- string) error {
 	log.Info().Msg("Listening to GPIO events")
 
+	send := func(action string) {
+		select {
+		case musicControl <- action:
+		case <-ctx.Done():
+		}
+	}
+
 	var err error
 	g.line, err = gpiocdev.RequestLine(
 		g.chip,
@@ -41,34 +59,72 @@ func (g *Gpio) Listen(musicControl chan string) error {
 			func(evt gpiocdev.LineEvent) {
 				log.Debug().Msg("GPIO event detected")
 				currentTime := time.Now()
-				g.lastEventsTime = append(g.lastEventsTime, currentTime)
-
-				// Drop all last events that occurred more than 1 second ago
-				oneSecondsAgo := time.Now().Add(-1 * time.Second)
-				var recentEvents []time.Time
-				for _, eventTime := range g.lastEventsTime {
-					if eventTime.After(oneSecondsAgo) {
-						recentEvents = append(recentEvents, eventTime)
-					}
-				}
-
-				g.lastEventsTime = recentEvents
 
-				if len(recentEvents) == 0 {
+				// The button pulls the line down while pressed: a long press is
+				// only known once released
+				if evt.Type == gpiocdev.LineEventFallingEdge {
+					g.pressTime = currentTime
 					return
 				}
-				// Determine the action based on number of recent events
-				if len(recentEvents) >= 2 {
-					log.Info().Msg("Stopping music")
-					musicControl <- StopMusic
+				pressTime := g.pressTime
+				g.pressTime = time.Time{}
+
+				if !pressTime.IsZero() && currentTime.Sub(pressTime) >= longPressDuration {
 					g.lastEventsTime = []time.Time{}
-				} else {
-					log.Info().Msg("Changing music")
-					musicControl <- ChangeMusic
+					if currentTime.Sub(pressTime) >= veryLongPressDuration {
+						log.Info().Msg("Switching profile")
+						send(NextProfile)
+						return
+					}
+					log.Info().Msg("Marking music as favourite")
+					send(FavouriteMusic)
+					return
 				}
+
+				// A short press counts both of its edges, as when every edge was a click
+				if !pressTime.IsZero() {
+					g.click(pressTime, send)
+				}
+				g.click(currentTime, send)
 			},
 		),
 	)
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	log.Info().Msg("Releasing GPIO line")
+	return g.line.Close()
+}
+
+// click counts an edge of a short press at the given time, and sends the
+// action of the clicks of the last second.
+func (g *Gpio) click(at time.Time, send func(action string)) {
+	g.lastEventsTime = append(g.lastEventsTime, at)
 
-	return err
+	// Drop all last events that occurred more than 1 second ago
+	oneSecondsAgo := at.Add(-1 * time.Second)
+	var recentEvents []time.Time
+	for _, eventTime := range g.lastEventsTime {
+		if eventTime.After(oneSecondsAgo) {
+			recentEvents = append(recentEvents, eventTime)
+		}
+	}
+
+	g.lastEventsTime = recentEvents
+
+	if len(recentEvents) == 0 {
+		return
+	}
+	// Determine the action based on number of recent events
+	if len(recentEvents) >= 2 {
+		log.Info().Msg("Stopping music")
+		send(StopMusic)
+		g.lastEventsTime = []time.Time{}
+	} else {
+		log.Info().Msg("Changing music")
+		send(ChangeMusic)
+	}
 }
@@ -0,0 +1,164 @@
+package settings
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source tells where the value of a setting comes from.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceHistory Source = "history"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Field is a struct field tagged with its environment variable, following the
+// Netflix/go-env tags used by the configuration: `env:"NAME,default=value"`.
+type Field struct {
+	Env     string // Env is the environment variable of the field.
+	Path    string // Path is the JSON path of the field, such as "audio.min_volume".
+	Default string // Default is the default value of the field, if any.
+	Source  Source // Source tells where the current value comes from.
+
+	value reflect.Value
+}
+
+// Fields returns the tagged fields of the struct pointed to by v, including
+// those of its nested structs.
+func Fields(v any) []*Field {
+	return structFields(reflect.ValueOf(v).Elem(), "")
+}
+
+func structFields(value reflect.Value, path string) []*Field {
+	var fields []*Field
+
+	for idx := 0; idx < value.NumField(); idx++ {
+		structField := value.Type().Field(idx)
+		if !structField.IsExported() {
+			continue
+		}
+
+		fieldPath := structField.Name
+		if name, _, _ := strings.Cut(structField.Tag.Get("json"), ","); name != "" && name != "-" {
+			fieldPath = name
+		}
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+
+		tag := structField.Tag.Get("env")
+		if tag == "" {
+			if structField.Type.Kind() == reflect.Struct && structField.Type != reflect.TypeOf(time.Time{}) {
+				fields = append(fields, structFields(value.Field(idx), fieldPath)...)
+			}
+			continue
+		}
+
+		field := &Field{Path: fieldPath, value: value.Field(idx)}
+		for i, option := range strings.Split(tag, ",") {
+			if i == 0 {
+				// Only the first of the alternative names is used
+				field.Env, _, _ = strings.Cut(option, "|")
+			} else if defaultValue, ok := strings.CutPrefix(option, "default="); ok {
+				field.Default = defaultValue
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+// Flag returns the command line flag of the field: AUDIO_MIN_VOLUME is set with --audio-min-volume.
+func (f *Field) Flag() string {
+	return strings.ReplaceAll(strings.ToLower(f.Env), "_", "-")
+}
+
+// Value returns the current value of the field.
+func (f *Field) Value() string {
+	return fmt.Sprint(f.value.Interface())
+}
+
+// Set parses the raw value into the field.
+func (f *Field) Set(raw string) error {
+	value := f.value
+
+	var err error
+	switch {
+	case value.Type() == reflect.TypeOf(time.Duration(0)):
+		var duration time.Duration
+		duration, err = time.ParseDuration(raw)
+		value.SetInt(int64(duration))
+	case value.Kind() == reflect.String:
+		value.SetString(raw)
+	case value.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(raw)
+		value.SetBool(b)
+	case value.CanInt():
+		var i int64
+		i, err = strconv.ParseInt(raw, 10, value.Type().Bits())
+		value.SetInt(i)
+	case value.CanUint():
+		var u uint64
+		u, err = strconv.ParseUint(raw, 10, value.Type().Bits())
+		value.SetUint(u)
+	case value.CanFloat():
+		var number float64
+		number, err = strconv.ParseFloat(raw, value.Type().Bits())
+		value.SetFloat(number)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.Env, value.Type())
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q: %w", f.Env, raw, err)
+	}
+	return nil
+}
+
+// Override is a value given through the environment or the command line.
+type Override struct {
+	Value  string
+	Source Source
+}
+
+// Overrides are the settings given through the environment or the command line,
+// by environment variable. They take precedence over the settings file.
+type Overrides map[string]Override
+
+// EnvOverrides returns the settings set in the environment.
+func EnvOverrides() Overrides {
+	overrides := make(Overrides)
+
+	var settings Settings
+	for _, field := range Fields(&settings) {
+		if value, ok := os.LookupEnv(field.Env); ok {
+			overrides[field.Env] = Override{Value: value, Source: SourceEnv}
+		}
+	}
+
+	return overrides
+}
+
+// apply sets the overridden fields of the settings.
+func (o Overrides) apply(settings *Settings) error {
+	for _, field := range Fields(settings) {
+		override, ok := o[field.Env]
+		if !ok {
+			continue
+		}
+		if err := field.Set(override.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
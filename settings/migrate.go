@@ -0,0 +1,103 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CurrentVersion is the version of the settings schema written by this program.
+const CurrentVersion = 1
+
+// ErrUnsupportedVersion is returned for settings written by a newer program.
+var ErrUnsupportedVersion = errors.New("unsupported settings version")
+
+// migrations upgrade the settings document from a version to the next one:
+// migrations[n] upgrades version n to version n+1.
+var migrations = []func(doc map[string]any) error{
+	migrateInvertedVolume,
+}
+
+// migrate upgrades the raw settings document to the current version.
+// It returns whether the document was changed.
+func migrate(doc map[string]any) (bool, error) {
+	version := 0
+	if value, ok := doc["version"]; ok {
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) || number < 0 {
+			return false, &ValidationError{Errors: []FieldError{{
+				Field:   "version",
+				Message: "must be a positive integer",
+			}}}
+		}
+		version = int(number)
+	}
+
+	if version > CurrentVersion {
+		return false, fmt.Errorf("%w: %d is newer than %d", ErrUnsupportedVersion, version, CurrentVersion)
+	}
+
+	migrated := version < CurrentVersion
+	for ; version < CurrentVersion; version++ {
+		if err := migrations[version](doc); err != nil {
+			return false, fmt.Errorf("failed to migrate settings from version %d: %w", version, err)
+		}
+		doc["version"] = version + 1
+	}
+
+	return migrated, nil
+}
+
+// migrateInvertedVolume converts the volume settings written with a base lower
+// than 1, where a negative step made the sound louder, to a base greater than 1
+// and a positive step. The gain of every volume is unchanged.
+func migrateInvertedVolume(doc map[string]any) error {
+	section, ok := doc["audio"].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	base, ok := section["base_volume"].(float64)
+	if !ok || base <= 0 || base >= 1 {
+		return nil
+	}
+
+	number := func(key string) float64 {
+		value, _ := section[key].(float64)
+		return value
+	}
+	minVolume, maxVolume := number("min_volume"), number("max_volume")
+
+	section["base_volume"] = 1 / base
+	section["default_volume"] = -number("default_volume")
+	section["min_volume"] = -maxVolume
+	section["max_volume"] = -minVolume
+	section["volume_step"] = math.Abs(number("volume_step"))
+
+	return nil
+}
+
+// decodeDocument parses the settings as a raw JSON object.
+func decodeDocument(data []byte) (map[string]any, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("%w: expected a JSON object", ErrInvalidSettings)
+	}
+	return doc, nil
+}
+
+// hasPath tells whether the document holds a value at the JSON path, such as "audio.min_volume".
+func hasPath(doc map[string]any, path string) bool {
+	key, rest, nested := strings.Cut(path, ".")
+	value, ok := doc[key]
+	if !ok || !nested {
+		return ok
+	}
+	child, ok := value.(map[string]any)
+	return ok && hasPath(child, rest)
+}
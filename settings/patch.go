@@ -0,0 +1,57 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// mergePatch applies the JSON merge patch to the settings and decodes the result.
+func mergePatch(settings Settings, patch []byte) (Settings, error) {
+	var patchDoc any
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return Settings{}, fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+
+	current, err := json.Marshal(settings)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var doc any
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return Settings{}, err
+	}
+
+	merged, err := json.Marshal(mergeValue(doc, patchDoc))
+	if err != nil {
+		return Settings{}, err
+	}
+
+	return Decode(bytes.NewReader(merged))
+}
+
+// mergeValue merges the patch into the target as described in RFC 7396:
+// objects are merged recursively, null removes a member and any other
+// value replaces the target.
+func mergeValue(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any)
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergeValue(targetObj[key], value)
+	}
+
+	return targetObj
+}
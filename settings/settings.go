@@ -1,54 +1,378 @@
 package settings
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Netflix/go-env"
+	"github.com/rs/zerolog/log"
 
 	"github.com/OhohLeo/hifi-baby/audio"
 )
 
+// ErrInvalidSettings is returned when the settings are not valid JSON.
+var ErrInvalidSettings = errors.New("invalid settings")
+
+// Config locates the settings file.
+type Config struct {
+	Path        string `env:"SETTINGS_PATH,default=settings.json"`
+	HistorySize int    `env:"SETTINGS_HISTORY,default=10"` // HistorySize is the number of saved versions kept.
+}
+
+// Settings are the preferences editable from the user interface.
 type Settings struct {
-	Audio audio.Settings `json:"audio"`
+	Version int            `json:"version"` // Version is the version of the settings schema.
+	Audio   audio.Settings `json:"audio"`
+}
 
-	path string
+// Default returns the built-in settings, given by the default values of
+// their env tags, used when no valid settings file is found.
+func Default() Settings {
+	settings := Settings{Version: CurrentVersion}
+	if err := env.Unmarshal(env.EnvSet{}, &settings); err != nil {
+		panic(fmt.Sprintf("invalid settings defaults: %v", err))
+	}
+	settings.Audio.EQPresets = audio.DefaultEQPresets()
+	return settings
 }
 
-func NewSettings(path string) (*Settings, error) {
-	if _, err := os.Stat(path); err != nil {
+// Store keeps the settings file up to date and notifies the subscribers of every update.
+type Store struct {
+	mutex       sync.Mutex
+	path        string
+	historySize int
+	file        Settings          // file holds the settings of the file, without the overrides.
+	overrides   Overrides         // overrides take precedence over the settings of the file.
+	settings    Settings          // settings are the effective settings, with the overrides.
+	sources     map[string]Source // sources tells where each setting comes from, by environment variable.
+	subscribers []func(Settings)
+}
+
+// NewStore loads the settings file, creating it from the defaults on first run.
+// When it is corrupt, the store falls back to the most recent valid version of
+// the history, then to the built-in defaults. Settings written with an older
+// schema are migrated. The overrides are then applied on top of the file.
+func NewStore(config Config, overrides Overrides) (*Store, error) {
+	store := &Store{
+		path:        config.Path,
+		historySize: config.HistorySize,
+		overrides:   overrides,
+		sources:     make(map[string]Source),
+	}
+
+	if err := store.init(); err != nil {
 		return nil, err
 	}
 
-	file, err := os.Open(path)
+	settings, err := store.effective(store.file)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid settings override: %w", err)
+	}
+	store.settings = settings
+	store.overrideSources()
+
+	return store, nil
+}
+
+// effective returns the settings of the file with the overrides applied, and validates them.
+func (s *Store) effective(file Settings) (Settings, error) {
+	settings := file
+	if err := s.overrides.apply(&settings); err != nil {
+		return Settings{}, err
+	}
+	if err := settings.Validate(); err != nil {
+		return Settings{}, err
+	}
+	return settings, nil
+}
+
+// overrideSources records the source of the overridden settings.
+func (s *Store) overrideSources() {
+	for _, field := range Fields(&s.settings) {
+		if override, ok := s.overrides[field.Env]; ok {
+			s.sources[field.Env] = override.Source
+		}
+	}
+}
+
+// keepOverridden keeps the value of the file for the overridden settings, so
+// that the temporary values of the overrides are never saved.
+func (s *Store) keepOverridden(newFile *Settings) {
+	current := Fields(&s.file)
+	for idx, field := range Fields(newFile) {
+		if _, ok := s.overrides[field.Env]; ok {
+			field.value.Set(current[idx].value)
+		}
+	}
+}
+
+// init loads the settings file or one of its fallbacks.
+func (s *Store) init() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Info().Msgf("Creating settings %q from the defaults", s.path)
+		s.useDefaults()
+		if err := s.save(s.file); err != nil {
+			log.Error().Err(err).Msg("Failed to create settings")
+		}
+		return nil
 	}
-	defer file.Close()
 
 	var settings Settings
+	migrated := false
+	if err == nil {
+		settings, migrated, err = decode(bytes.NewReader(data))
+	}
+	if errors.Is(err, ErrUnsupportedVersion) {
+		// Falling back would lose the settings of the newer program
+		return fmt.Errorf("failed to load settings %q: %w", s.path, err)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to load settings %q", s.path)
+		s.fallback()
+		return nil
+	}
+
+	s.file = settings
+	s.setSources(SourceDefault)
+	if doc, err := decodeDocument(data); err == nil {
+		for _, field := range Fields(&settings) {
+			if hasPath(doc, field.Path) {
+				s.sources[field.Env] = SourceFile
+			}
+		}
+	}
+
+	if migrated {
+		log.Info().Msgf("Migrating settings %q to version %d", s.path, CurrentVersion)
+		if err := s.save(settings); err != nil {
+			log.Error().Err(err).Msg("Failed to save migrated settings")
+		}
+		return nil
+	}
+
+	// Keep the settings found at startup in an empty history
+	if ids, err := s.versionIDs(); err == nil && len(ids) == 0 {
+		if data, err := encode(settings); err == nil {
+			if err := s.addVersion(data); err != nil {
+				log.Warn().Err(err).Msg("Failed to save settings history")
+			}
+		}
+	}
+
+	return nil
+}
+
+// fallback uses the most recent valid version of the history, or the defaults.
+// The settings file is left untouched until the next update.
+func (s *Store) fallback() {
+	ids, err := s.versionIDs()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read settings history")
+	}
+
+	for _, id := range ids {
+		version, err := s.readVersion(id)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Ignoring invalid settings version %s", id)
+			continue
+		}
+
+		log.Warn().Msgf("Using settings version %s saved at %s", id, version.SavedAt.Format(time.RFC3339))
+		s.file = version.Settings
+		s.setSources(SourceHistory)
+		return
+	}
+
+	log.Warn().Msg("Using default settings")
+	s.useDefaults()
+}
+
+func (s *Store) useDefaults() {
+	s.file = Default()
+	s.setSources(SourceDefault)
+}
+
+// setSources sets the source of every setting.
+func (s *Store) setSources(source Source) {
+	for _, field := range Fields(&s.file) {
+		s.sources[field.Env] = source
+	}
+}
+
+// Fields returns the current settings with their source.
+func (s *Store) Fields() []*Field {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	// Decode the JSON config file into the settings field
-	decoder := json.NewDecoder(file)
+	settings := s.settings
+	fields := Fields(&settings)
+	for _, field := range fields {
+		field.Source = s.sources[field.Env]
+	}
+	return fields
+}
+
+// Path returns the path of the settings file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Decode reads JSON settings, refusing unknown fields, migrates them to the
+// current version and validates them.
+// Values of the wrong type are reported as a ValidationError.
+func Decode(src io.Reader) (Settings, error) {
+	settings, _, err := decode(src)
+	return settings, err
+}
+
+// decode works as Decode and tells whether the settings were migrated.
+func decode(src io.Reader) (Settings, bool, error) {
+	settings := Default()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return settings, false, err
+	}
+
+	doc, err := decodeDocument(data)
+	if err != nil {
+		return settings, false, err
+	}
+
+	migrated, err := migrate(doc)
+	if err != nil {
+		return settings, false, err
+	}
+
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return settings, false, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&settings); err != nil {
-		return nil, err
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return settings, false, &ValidationError{Errors: []FieldError{{
+				Field:   typeErr.Field,
+				Message: "must be a " + jsonTypeName(typeErr.Type),
+			}}}
+		}
+		return settings, false, fmt.Errorf("%w: %v", ErrInvalidSettings, err)
 	}
 
-	settings.path = path
+	return settings, migrated, settings.Validate()
+}
+
+// Get returns the current settings.
+func (s *Store) Get() Settings {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.settings
+}
+
+// Subscribe registers a function called with the new settings after every update.
+// The subscribers are called in order and must not update the settings.
+func (s *Store) Subscribe(fn func(Settings)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	return &settings, nil
+	s.subscribers = append(s.subscribers, fn)
 }
 
-func (s *Settings) Update(newSettings *Settings) error {
-	updatedSettingsData, err := json.Marshal(newSettings)
+// Update validates and saves the new settings, then notifies the subscribers.
+// The overridden settings keep the value of the file. It returns the effective settings.
+func (s *Store) Update(newSettings Settings) (Settings, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keepOverridden(&newSettings)
+	return s.update(newSettings)
+}
+
+// Patch applies a JSON merge patch (RFC 7396) to the settings of the file,
+// then validates and saves the result. It returns the effective settings.
+func (s *Store) Patch(patch []byte) (Settings, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	newFile, err := mergePatch(s.file, patch)
 	if err != nil {
-		return err
+		return Settings{}, err
 	}
 
-	// Write the updated configuration back to file
-	err = os.WriteFile(s.path, updatedSettingsData, 0644)
+	return s.update(newFile)
+}
+
+// update validates and saves the settings of the file, applies the overrides
+// and notifies the subscribers of the effective settings, with the mutex held.
+func (s *Store) update(newFile Settings) (Settings, error) {
+	newFile.Version = CurrentVersion
+	if err := newFile.Validate(); err != nil {
+		return Settings{}, err
+	}
+	newSettings, err := s.effective(newFile)
 	if err != nil {
+		return Settings{}, err
+	}
+
+	if err := s.save(newFile); err != nil {
+		return Settings{}, err
+	}
+	s.setSources(SourceFile)
+	s.settings = newSettings
+	s.overrideSources()
+
+	for _, subscriber := range s.subscribers {
+		subscriber(newSettings)
+	}
+
+	return newSettings, nil
+}
+
+// save atomically writes the settings of the file and adds them to the history.
+func (s *Store) save(newFile Settings) error {
+	data, err := encode(newFile)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(s.path, data); err != nil {
 		return fmt.Errorf("failed to write to file at path %q: %w", s.path, err)
 	}
+	s.file = newFile
+
+	if err := s.addVersion(data); err != nil {
+		log.Warn().Err(err).Msg("Failed to save settings history")
+	}
 
 	return nil
 }
+
+// jsonTypeName returns the JSON name of the type expected for a setting.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,207 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrVersionNotFound is returned when restoring an unknown version.
+var ErrVersionNotFound = errors.New("settings version not found")
+
+// historySuffix is appended to the settings path to name the history directory.
+const historySuffix = ".history"
+
+// Version is a saved version of the settings.
+type Version struct {
+	ID       string    `json:"id"`       // ID identifies the version in the history.
+	SavedAt  time.Time `json:"saved_at"` // SavedAt is when the version was saved.
+	Settings Settings  `json:"settings"`
+}
+
+// writeFileAtomic replaces the file at the given path so that it holds either
+// the previous or the new data, even after a power cut: the data is written to
+// a temporary file which is synced and renamed, then the directory is synced.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir makes the renames in the directory durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// historyDir returns the directory holding the saved versions of the settings.
+func (s *Store) historyDir() string {
+	return s.path + historySuffix
+}
+
+// addVersion saves the settings in the history and removes the oldest versions
+// beyond the configured history size.
+func (s *Store) addVersion(data []byte) error {
+	if s.historySize <= 0 {
+		return nil
+	}
+
+	dir := s.historyDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// The timestamp keeps the versions sorted and their save date
+	id := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := writeFileAtomic(filepath.Join(dir, id+".json"), data); err != nil {
+		return err
+	}
+
+	ids, err := s.versionIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids[min(len(ids), s.historySize):] {
+		if err := os.Remove(filepath.Join(dir, id+".json")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionIDs returns the IDs of the saved versions, most recent first.
+func (s *Store) versionIDs() ([]string, error) {
+	entries, err := os.ReadDir(s.historyDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if _, err := strconv.ParseInt(id, 10, 64); ok && err == nil && entry.Type().IsRegular() {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) > len(ids[j])
+		}
+		return ids[i] > ids[j]
+	})
+
+	return ids, nil
+}
+
+// readVersion loads a saved version of the settings.
+func (s *Store) readVersion(id string) (*Version, error) {
+	timestamp, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrVersionNotFound, id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.historyDir(), id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrVersionNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	settings, _, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Version{
+		ID:       id,
+		SavedAt:  time.Unix(0, timestamp),
+		Settings: settings,
+	}, nil
+}
+
+// History returns the valid saved versions of the settings, most recent first.
+func (s *Store) History() ([]*Version, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ids, err := s.versionIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*Version, 0, len(ids))
+	for _, id := range ids {
+		version, err := s.readVersion(id)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// Restore saves a previous version as the settings of the file, and returns
+// the effective settings.
+func (s *Store) Restore(id string) (Settings, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	version, err := s.readVersion(id)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	return s.update(version.Settings)
+}
+
+// encode returns the content of the settings file.
+func encode(settings Settings) ([]byte, error) {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,180 @@
+package settings
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+)
+
+// FieldError describes why a setting is invalid.
+type FieldError struct {
+	Field   string `json:"field"`   // Field is the JSON path of the setting, such as "audio.min_volume".
+	Message string `json:"message"` // Message explains the expected value.
+}
+
+// ValidationError lists the invalid settings.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for idx, fieldErr := range e.Errors {
+		messages[idx] = fieldErr.Field + ": " + fieldErr.Message
+	}
+	return "invalid settings: " + strings.Join(messages, ", ")
+}
+
+// Validate checks the settings against the rules of each section.
+func (s Settings) Validate() error {
+	var errs []FieldError
+	errs = append(errs, validateAudio("audio", s.Audio)...)
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validateAudio checks the volume settings. The gain is base_volume to the power
+// of the volume: a base above 1 and a positive step make "volume up" louder.
+func validateAudio(prefix string, settings audio.Settings) []FieldError {
+	var errs []FieldError
+	invalid := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{
+			Field:   prefix + "." + field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if settings.BaseVolume <= 1 {
+		invalid("base_volume", "must be greater than 1")
+	}
+	if settings.VolumeStep <= 0 {
+		invalid("volume_step", "must be greater than 0")
+	}
+	if settings.MinVolume >= settings.MaxVolume {
+		invalid("min_volume", "must be lower than max_volume (%g)", settings.MaxVolume)
+	}
+	if settings.DefaultVolume < settings.MinVolume || settings.DefaultVolume > settings.MaxVolume {
+		invalid("default_volume", "must be between min_volume (%g) and max_volume (%g)",
+			settings.MinVolume, settings.MaxVolume)
+	}
+
+	if settings.Generator.Volume < settings.MinVolume || settings.Generator.Volume > settings.MaxVolume {
+		invalid("generator.volume", "must be between min_volume (%g) and max_volume (%g)",
+			settings.MinVolume, settings.MaxVolume)
+	}
+	if settings.Generator.Timer < 0 {
+		invalid("generator.timer", "must not be negative")
+	}
+
+	errs = append(errs, ValidateEQ(prefix+".eq", settings.EQ)...)
+	errs = append(errs, validateNight(prefix+".night", settings.Night, settings)...)
+
+	names := make(map[string]bool)
+	for idx, preset := range settings.EQPresets {
+		presetPrefix := fmt.Sprintf("eq_presets[%d]", idx)
+		switch {
+		case strings.TrimSpace(preset.Name) == "":
+			invalid(presetPrefix+".name", "must not be empty")
+		case names[preset.Name]:
+			invalid(presetPrefix+".name", "must be unique, %q is already used", preset.Name)
+		}
+		names[preset.Name] = true
+		errs = append(errs, ValidateEQ(prefix+"."+presetPrefix+".eq", preset.EQ)...)
+	}
+
+	return errs
+}
+
+// Limits of the equalizer settings.
+const (
+	maxEQGain      = 12
+	maxEQBands     = 10
+	minEQFrequency = 20
+	maxEQFrequency = 20000
+	minEQQ         = 0.1
+	maxEQQ         = 10.0
+)
+
+// ValidateEQ checks the gains, the bands and the balance of the equalizer.
+func ValidateEQ(prefix string, eq audio.EQ) []FieldError {
+	var errs []FieldError
+	invalid := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{
+			Field:   prefix + "." + field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if math.Abs(eq.Bass) > maxEQGain {
+		invalid("bass", "must be between -%d and %d dB", maxEQGain, maxEQGain)
+	}
+	if math.Abs(eq.Treble) > maxEQGain {
+		invalid("treble", "must be between -%d and %d dB", maxEQGain, maxEQGain)
+	}
+	if math.Abs(eq.Balance) > 1 {
+		invalid("balance", "must be between -1 and 1")
+	}
+	if len(eq.Bands) > maxEQBands {
+		invalid("bands", "must have at most %d bands", maxEQBands)
+	}
+	for idx, band := range eq.Bands {
+		if band.Frequency < minEQFrequency || band.Frequency > maxEQFrequency {
+			invalid(fmt.Sprintf("bands[%d].frequency", idx), "must be between %d and %d Hz", minEQFrequency, maxEQFrequency)
+		}
+		if math.Abs(band.Gain) > maxEQGain {
+			invalid(fmt.Sprintf("bands[%d].gain", idx), "must be between -%d and %d dB", maxEQGain, maxEQGain)
+		}
+		if band.Q < minEQQ || band.Q > maxEQQ {
+			invalid(fmt.Sprintf("bands[%d].q", idx), "must be between %g and %g", minEQQ, maxEQQ)
+		}
+	}
+
+	return errs
+}
+
+// validateNight checks the compression, the volume ceiling and the schedule of the night mode.
+func validateNight(prefix string, night audio.NightSettings, settings audio.Settings) []FieldError {
+	var errs []FieldError
+	invalid := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{
+			Field:   prefix + "." + field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if night.MaxVolume < settings.MinVolume {
+		invalid("max_volume", "must not be lower than min_volume (%g)", settings.MinVolume)
+	}
+	if night.Threshold > 0 {
+		invalid("threshold", "must not be greater than 0 dB")
+	}
+	if night.Ratio < 1 {
+		invalid("ratio", "must be at least 1")
+	}
+	if night.Treble > 0 || night.Treble < -maxEQGain {
+		invalid("treble", "must be between -%d and 0 dB", maxEQGain)
+	}
+	if (night.Start == "") != (night.End == "") {
+		invalid("start", "must be set with end, or both left empty")
+	}
+	bounds := []struct{ field, value string }{
+		{"start", night.Start},
+		{"end", night.End},
+	}
+	for _, bound := range bounds {
+		if _, err := time.Parse("15:04", bound.value); bound.value != "" && err != nil {
+			invalid(bound.field, "must be formatted as 15:04")
+		}
+	}
+	if night.Start != "" && night.Start == night.End {
+		invalid("end", "must differ from start")
+	}
+
+	return errs
+}
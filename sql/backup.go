@@ -0,0 +1,96 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// restoredTables are the tables replaced when restoring a backup.
+var restoredTables = []string{"tracks", "listened_tracks", "daily_listens", "profiles"}
+
+// Snapshot writes a consistent copy of the database to a new file, while the
+// database remains in use.
+func (db *Database) Snapshot(path string) error {
+	if err := vacuumInto(db.orm, path); err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the database schema.
+func (db *Database) SchemaVersion() (int, error) {
+	return currentVersion(db.orm)
+}
+
+// CheckBackup checks that the database file is a valid backup, migrating it to
+// the current schema. Backups made by a newer program are refused.
+func CheckBackup(path string) error {
+	orm, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open database backup: %w", err)
+	}
+	conn, err := orm.DB()
+	if err != nil {
+		return fmt.Errorf("failed to open database backup: %w", err)
+	}
+	defer conn.Close()
+
+	var result string
+	if err := orm.Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return fmt.Errorf("invalid database backup: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("invalid database backup: %s", result)
+	}
+
+	// The backup is a copy: it is migrated without being backed up first
+	if err := migrate(orm, ""); err != nil {
+		return fmt.Errorf("invalid database backup: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreFrom replaces the content of the database by the one of the backup
+// file, checked with CheckBackup. The data is copied within a transaction, so
+// that the database stays usable and consistent during the restore.
+func (db *Database) RestoreFrom(path string) error {
+	// The attached database is only visible to its connection
+	return db.orm.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("ATTACH DATABASE ? AS backup", path).Error; err != nil {
+			return fmt.Errorf("failed to open database backup: %w", err)
+		}
+		defer conn.Exec("DETACH DATABASE backup")
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			for _, table := range restoredTables {
+				if err := restoreTable(tx, table); err != nil {
+					return fmt.Errorf("failed to restore %s: %w", table, err)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// restoreTable replaces the rows of the table by those of the backup.
+func restoreTable(tx *gorm.DB, table string) error {
+	var columns []string
+	err := tx.Raw("SELECT name FROM pragma_table_info(?, 'main')", table).Scan(&columns).Error
+	if err != nil {
+		return err
+	}
+
+	quoted := "`" + strings.Join(columns, "`, `") + "`"
+	if err := tx.Exec(fmt.Sprintf("DELETE FROM main.`%s`", table)).Error; err != nil {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf("INSERT INTO main.`%s` (%s) SELECT %s FROM backup.`%s`",
+		table, quoted, quoted, table)).Error
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -15,6 +16,9 @@ import (
 type Config struct {
 	Path    string        `env:"DATABASE_PATH,default=./hifi-baby.db"`
 	Timeout time.Duration `env:"DATABASE_TIMEOUT,default=10s"`
+
+	RetentionDays     int           `env:"HISTORY_RETENTION_DAYS,default=365"`     // RetentionDays is the number of days listens are kept before being rolled up, 0 to keep them forever.
+	RetentionInterval time.Duration `env:"HISTORY_RETENTION_INTERVAL,default=24h"` // RetentionInterval is the interval between two runs of the retention policy.
 }
 
 // Database handles the database.
@@ -31,37 +35,85 @@ func NewDatabase(cfg Config) (*Database, error) {
 		return nil, fmt.Errorf("failed to initialize gorm: %w", err)
 	}
 
-	if err := orm.AutoMigrate(&ListenedTrack{}); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	if err := migrate(orm, cfg.Path); err != nil {
+		return nil, err
 	}
 
 	return &Database{orm: orm}, nil
 }
 
+// Close closes the database connection.
+func (db *Database) Close() error {
+	conn, err := db.orm.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	return conn.Close()
+}
+
 // ListenedTrack represents a track that has been listened to.
+// Listens recorded before the end reason was tracked have an empty end reason
+// and no positions nor track duration.
 type ListenedTrack struct {
 	gorm.Model `json:"-"`
 
-	TrackName string    `json:"track_name"`
-	At        time.Time `json:"at"`
-	During    int64     `json:"during"`
+	TrackID       uuid.UUID `gorm:"type:uuid;index" json:"track_id"`
+	ProfileID     uuid.UUID `gorm:"type:uuid;index" json:"profile_id"` // ProfileID is the active profile during the listen, if any.
+	TrackName     string    `json:"track_name"`
+	At            time.Time `json:"at"`
+	During        int64     `json:"during"`         // During is the listening time in seconds.
+	Source        string    `json:"source"`         // Source tells what started the playback: gpio, http, schedule or card.
+	EndReason     string    `json:"end_reason"`     // EndReason tells why the playback ended: finished, skipped, stopped, timer or limit.
+	StartPosition int64     `json:"start_position"` // StartPosition is the position in seconds at the start of the playback.
+	EndPosition   int64     `json:"end_position"`   // EndPosition is the position in seconds at the end of the playback.
+	TrackDuration int64     `json:"track_duration"` // TrackDuration is the duration of the track in seconds.
 }
 
 // AddListenedTrack adds a listened track to the database.
-func (db *Database) AddListenedTrack(track *audio.Track, when time.Time, during int64) error {
-	return db.orm.Create(&ListenedTrack{
-		TrackName: track.Name,
-		At:        when,
-		During:    during,
-	}).Error
+func (db *Database) AddListenedTrack(listen *audio.Listen) error {
+	listenedTrack := &ListenedTrack{
+		TrackID:       listen.Track.ID,
+		TrackName:     listen.Track.Name,
+		At:            listen.At,
+		During:        int64(listen.During.Seconds()),
+		Source:        string(listen.Source),
+		EndReason:     string(listen.EndReason),
+		StartPosition: int64(listen.StartPosition.Seconds()),
+		EndPosition:   int64(listen.EndPosition.Seconds()),
+		TrackDuration: int64(listen.TrackDuration.Seconds()),
+	}
+	if listen.Profile == nil {
+		return db.orm.Omit("ProfileID").Create(listenedTrack).Error
+	}
+	listenedTrack.ProfileID = listen.Profile.ID
+	return db.orm.Create(listenedTrack).Error
+}
+
+// Cursor is the position of a listened track in the history, the zero cursor
+// being the most recent end of the history.
+type Cursor struct {
+	At time.Time
+	ID uint
 }
 
-// ListenedTracks gets all listened tracks since the given time.
-func (db *Database) ListenedTracks(since time.Time) ([]*ListenedTrack, error) {
+// ListenedTracks gets at most limit listened tracks of the profile, or of all
+// the profiles for uuid.Nil, since the given time, most recent first, starting
+// after the cursor.
+func (db *Database) ListenedTracks(profileID uuid.UUID, since time.Time, after Cursor, limit int) ([]*ListenedTrack, error) {
+	// Times are stored as text in the local time zone of the box
 	var tracks []*ListenedTrack
 	query := db.orm.Model(&ListenedTrack{}).
-		Where("at > ?", since).
+		Where("at > ?", since.Local())
+	if profileID != uuid.Nil {
+		query = query.Where("profile_id = ?", profileID)
+	}
+	if after != (Cursor{}) {
+		query = query.Where("at < ? OR (at = ? AND id < ?)", after.At.Local(), after.At.Local(), after.ID)
+	}
+	query = query.
 		Order("at DESC").
+		Order("id DESC").
+		Limit(limit).
 		Find(&tracks)
 	if err := query.Error; err != nil {
 		return nil, fmt.Errorf("failed to get listened tracks: %w", err)
@@ -69,25 +121,64 @@ func (db *Database) ListenedTracks(since time.Time) ([]*ListenedTrack, error) {
 	return tracks, nil
 }
 
+// completionRatio is the SQL expression of the part of the track played by a
+// listen, between 0 and 1. It is NULL for listens without a known track duration.
+const completionRatio = "CASE WHEN listened_tracks.track_duration > 0 THEN " +
+	"MIN(1.0, MAX(0, listened_tracks.end_position - listened_tracks.start_position) * 1.0 / listened_tracks.track_duration) END"
+
 // MostListenedTrack represents a track that has been listened to.
 type MostListenedTrack struct {
-	TrackName string `json:"track_name"`
-	Since     string `json:"since"`
-	During    int64  `json:"during"`
-	Count     int    `json:"count"`
+	TrackID     uuid.UUID `json:"track_id"`
+	TrackName   string    `json:"track_name"`
+	Since       string    `json:"since"`
+	During      int64     `json:"during"`
+	Count       int       `json:"count"`
+	Completions float64   `json:"completions"` // Completions is the number of listens weighted by their completion ratio.
+	Completion  float64   `json:"completion"`  // Completion is the average completion ratio of the listens.
 }
 
-// MostListenedTracks gets the most listened tracks since the given time.
-func (db *Database) MostListenedTracks(since time.Time, topNb int) ([]*MostListenedTrack, error) {
+// MostListenedTracks gets the most listened tracks of the profile, or of all
+// the profiles for uuid.Nil, since the given time, ordered by completed
+// listens so that long tracks are not favoured over short ones.
+// Listens are grouped by track ID and reported under the current track name;
+// history that could not be linked to a track is grouped by its name. The days
+// rolled up by the retention policy are included, their listens counting as
+// known completions.
+func (db *Database) MostListenedTracks(profileID uuid.UUID, since time.Time, topNb int) ([]*MostListenedTrack, error) {
+	listenedFilter, dailyFilter := "", ""
+	args := []any{since.Local()}
+	if profileID != uuid.Nil {
+		listenedFilter = " AND listened_tracks.profile_id = ?"
+		args = append(args, profileID)
+	}
+	args = append(args, rolledUpDate(since))
+	if profileID != uuid.Nil {
+		dailyFilter = " AND daily_listens.profile_key = ?"
+		args = append(args, profileID.String())
+	}
+	args = append(args, topNb)
+
 	var mostListenedTracks []*MostListenedTrack
-	query := db.orm.Model(&ListenedTrack{}).
-		Select("track_name, min(at) as since, sum(during) as during, count(track_name) as count").
-		Where("at > ?", since).
-		Group("track_name").
-		Order("during DESC").
-		Order("since DESC").
-		Limit(topNb).
-		Find(&mostListenedTracks)
+	query := db.orm.Raw("SELECT track_id, track_name, min(since) AS since, sum(during) AS during, "+
+		"sum(count) AS count, sum(completions) AS completions, "+
+		"COALESCE(sum(completions) / NULLIF(sum(known), 0), 0) AS completion "+
+		"FROM ("+
+		"SELECT COALESCE(listened_tracks.track_id, 'name:' || listened_tracks.track_name) AS track_key, "+
+		"listened_tracks.track_id, COALESCE(tracks.name, listened_tracks.track_name) AS track_name, "+
+		"listened_tracks.at AS since, listened_tracks.during, 1 AS count, "+
+		"COALESCE("+completionRatio+", 0) AS completions, "+
+		"CASE WHEN listened_tracks.track_duration > 0 THEN 1 ELSE 0 END AS known "+
+		"FROM `listened_tracks` LEFT JOIN `tracks` ON tracks.id = listened_tracks.track_id "+
+		"WHERE listened_tracks.deleted_at IS NULL AND listened_tracks.at > ?"+listenedFilter+" "+
+		"UNION ALL "+
+		"SELECT daily_listens.track_key, daily_listens.track_id, "+
+		"COALESCE(tracks.name, daily_listens.track_name), daily_listens.date, daily_listens.during, "+
+		"daily_listens.count, daily_listens.completions, daily_listens.count "+
+		"FROM `daily_listens` LEFT JOIN `tracks` ON tracks.id = daily_listens.track_id "+
+		"WHERE daily_listens.date >= ?"+dailyFilter+
+		") GROUP BY track_key "+
+		"ORDER BY completions DESC, during DESC, since DESC LIMIT ?", args...).
+		Scan(&mostListenedTracks)
 	if err := query.Error; err != nil {
 		return nil, fmt.Errorf("failed to get most listened tracks: %w", err)
 	}
@@ -0,0 +1,63 @@
+package sql
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestListenedTracksSinceUTC(t *testing.T) {
+	// Times are stored in the local time zone: run ahead of UTC
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	db, err := NewDatabase(Config{Path: filepath.Join(t.TempDir(), "hifi-baby.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	at := time.Date(2024, time.March, 1, 8, 0, 0, 0, time.Local)
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		err := db.orm.Omit("TrackID", "ProfileID").Create(&ListenedTrack{
+			TrackName: name,
+			At:        at,
+			During:    60,
+		}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Half an hour after the listens, as sent by a client in UTC
+	since := at.Add(30 * time.Minute).UTC()
+	listens, err := db.ListenedTracks(uuid.Nil, since, Cursor{}, 10)
+	if err != nil || len(listens) != 0 {
+		t.Fatalf("expected no listen since %s, got %d (%v)", since, len(listens), err)
+	}
+	most, err := db.MostListenedTracks(uuid.Nil, since, 10)
+	if err != nil || len(most) != 0 {
+		t.Fatalf("expected no most listened track since %s, got %d (%v)", since, len(most), err)
+	}
+
+	// Half an hour before, both listens are found
+	since = at.Add(-30 * time.Minute).UTC()
+	listens, err = db.ListenedTracks(uuid.Nil, since, Cursor{}, 1)
+	if err != nil || len(listens) != 1 || listens[0].TrackName != "b.mp3" {
+		t.Fatalf("expected the last listen since %s, got %+v (%v)", since, listens, err)
+	}
+	most, err = db.MostListenedTracks(uuid.Nil, since, 10)
+	if err != nil || len(most) != 2 {
+		t.Fatalf("expected 2 most listened tracks since %s, got %d (%v)", since, len(most), err)
+	}
+
+	// The cursor sent back in UTC continues after the first page
+	after := Cursor{At: listens[0].At.UTC(), ID: listens[0].ID}
+	listens, err = db.ListenedTracks(uuid.Nil, since, after, 10)
+	if err != nil || len(listens) != 1 || listens[0].TrackName != "a.mp3" {
+		t.Fatalf("expected the first listen after the cursor, got %+v (%v)", listens, err)
+	}
+}
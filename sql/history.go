@@ -0,0 +1,209 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// HistoryRecord is a listened track as exported and imported, carrying the
+// name and fingerprint of its track to match it on another installation.
+type HistoryRecord struct {
+	At            time.Time `json:"at"`
+	TrackID       uuid.UUID `json:"track_id"`
+	TrackName     string    `json:"track_name"`
+	Fingerprint   string    `json:"fingerprint"`
+	ProfileID     uuid.UUID `json:"profile_id"` // ProfileID is the active profile during the listen, uuid.Nil if none.
+	During        int64     `json:"during"`
+	Source        string    `json:"source"`
+	EndReason     string    `json:"end_reason"`
+	StartPosition int64     `json:"start_position"`
+	EndPosition   int64     `json:"end_position"`
+	TrackDuration int64     `json:"track_duration"`
+}
+
+// ImportResult counts the records of an history import.
+type ImportResult struct {
+	Imported   int `json:"imported"`   // Imported is the number of listened tracks added.
+	Duplicates int `json:"duplicates"` // Duplicates is the number of records already in the history.
+}
+
+// ExportHistory calls fn with the listened tracks between from and to, oldest
+// first. A zero bound is ignored. Rows are read one at a time.
+func (db *Database) ExportHistory(from, to time.Time, fn func(record *HistoryRecord) error) error {
+	query := db.orm.Model(&ListenedTrack{}).
+		Select("listened_tracks.at, listened_tracks.track_id, " +
+			"COALESCE(tracks.name, listened_tracks.track_name) as track_name, " +
+			"COALESCE(tracks.fingerprint, '') as fingerprint, listened_tracks.profile_id, listened_tracks.during, " +
+			"COALESCE(listened_tracks.source, '') as source, " +
+			"COALESCE(listened_tracks.end_reason, '') as end_reason, " +
+			"COALESCE(listened_tracks.start_position, 0) as start_position, " +
+			"COALESCE(listened_tracks.end_position, 0) as end_position, " +
+			"COALESCE(listened_tracks.track_duration, 0) as track_duration").
+		Joins("LEFT JOIN tracks ON tracks.id = listened_tracks.track_id").
+		Order("listened_tracks.at")
+	// Times are stored as text in the local time zone of the box
+	if !from.IsZero() {
+		query = query.Where("listened_tracks.at >= ?", from.Local())
+	}
+	if !to.IsZero() {
+		query = query.Where("listened_tracks.at < ?", to.Local())
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return fmt.Errorf("failed to get listened tracks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record HistoryRecord
+		if err := db.orm.ScanRows(rows, &record); err != nil {
+			return fmt.Errorf("failed to read listened track: %w", err)
+		}
+		if err := fn(&record); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// importBatchSize is the number of records imported per transaction, so that
+// the listens recorded by the player during an import are not blocked.
+const importBatchSize = 500
+
+// ImportHistory adds the records returned by next until it returns io.EOF.
+// Records are matched to the current tracks by fingerprint, then by name, and
+// those already in the history for the same track and time are skipped.
+// All the records are read before any is imported, so nothing is imported if
+// a record is invalid. They are then imported in batches: after a database
+// error, importing the same history again adds the remaining records.
+func (db *Database) ImportHistory(next func() (*HistoryRecord, error)) (*ImportResult, error) {
+	var records []*HistoryRecord
+	for {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	result := &ImportResult{}
+	for start := 0; start < len(records); start += importBatchSize {
+		batch := records[start:min(start+importBatchSize, len(records))]
+
+		var batchResult ImportResult
+		err := db.orm.Transaction(func(tx *gorm.DB) error {
+			for _, record := range batch {
+				imported, err := importRecord(tx, record)
+				if err != nil {
+					return err
+				}
+				if imported {
+					batchResult.Imported++
+				} else {
+					batchResult.Duplicates++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed after importing %d listened tracks: %w", result.Imported, err)
+		}
+
+		result.Imported += batchResult.Imported
+		result.Duplicates += batchResult.Duplicates
+	}
+
+	return result, nil
+}
+
+// importRecord adds the record unless it is already in the history.
+// It tells whether the record was added.
+func importRecord(tx *gorm.DB, record *HistoryRecord) (bool, error) {
+	listened, err := listenedTrackOf(tx, record)
+	if err != nil {
+		return false, err
+	}
+
+	duplicate, err := hasListenedTrack(tx, listened)
+	if err != nil || duplicate {
+		return false, err
+	}
+
+	var omit []string
+	if listened.TrackID == uuid.Nil {
+		// Left unlinked until a track with the same name is registered
+		omit = append(omit, "TrackID")
+	}
+	if listened.ProfileID == uuid.Nil {
+		omit = append(omit, "ProfileID")
+	}
+	create := tx
+	if len(omit) > 0 {
+		create = tx.Omit(omit...)
+	}
+	if err := create.Create(listened).Error; err != nil {
+		return false, fmt.Errorf("failed to add listened track: %w", err)
+	}
+	return true, nil
+}
+
+// listenedTrackOf returns the listened track of the record, linked to the
+// matching current track, if any.
+func listenedTrackOf(tx *gorm.DB, record *HistoryRecord) (*ListenedTrack, error) {
+	listened := &ListenedTrack{
+		ProfileID:     record.ProfileID,
+		TrackName:     record.TrackName,
+		At:            record.At.Local(),
+		During:        record.During,
+		Source:        record.Source,
+		EndReason:     record.EndReason,
+		StartPosition: record.StartPosition,
+		EndPosition:   record.EndPosition,
+		TrackDuration: record.TrackDuration,
+	}
+
+	var track Track
+	query := tx.Where("name = ?", record.TrackName)
+	if record.Fingerprint != "" {
+		query = tx.Where("fingerprint = ?", record.Fingerprint).
+			Or("name = ?", record.TrackName).
+			// Prefer the fingerprint over the name
+			Order(gorm.Expr("fingerprint = ? DESC", record.Fingerprint))
+	}
+	err := query.First(&track).Error
+	switch {
+	case err == nil:
+		listened.TrackID = track.ID
+		listened.TrackName = track.Name
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, fmt.Errorf("failed to find track %q: %w", record.TrackName, err)
+	}
+
+	return listened, nil
+}
+
+// hasListenedTrack tells whether the track was already listened at the same time.
+func hasListenedTrack(tx *gorm.DB, listened *ListenedTrack) (bool, error) {
+	query := tx.Model(&ListenedTrack{}).Where("at = ?", listened.At)
+	if listened.TrackID != uuid.Nil {
+		query = query.Where("track_id = ?", listened.TrackID)
+	} else {
+		query = query.Where("track_id IS NULL AND track_name = ?", listened.TrackName)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to find listened track: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,126 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// historyRecords returns the given number of records, one minute apart.
+func historyRecords(count int) []*HistoryRecord {
+	start := time.Date(2024, time.March, 1, 8, 0, 0, 0, time.Local)
+	records := make([]*HistoryRecord, count)
+	for idx := range records {
+		records[idx] = &HistoryRecord{
+			At:        start.Add(time.Duration(idx) * time.Minute),
+			TrackName: fmt.Sprintf("track%d.mp3", idx%7),
+			During:    60,
+			Source:    "gpio",
+			EndReason: "finished",
+		}
+	}
+	return records
+}
+
+// recordReader returns the function reading the records one by one, calling
+// read before each of them.
+func recordReader(records []*HistoryRecord, read func(idx int) error) func() (*HistoryRecord, error) {
+	idx := 0
+	return func() (*HistoryRecord, error) {
+		if idx == len(records) {
+			return nil, io.EOF
+		}
+		if read != nil {
+			if err := read(idx); err != nil {
+				return nil, err
+			}
+		}
+		idx++
+		return records[idx-1], nil
+	}
+}
+
+// countListens returns the number of listened tracks.
+func countListens(t *testing.T, db *Database) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.orm.Model(&ListenedTrack{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestImportHistory(t *testing.T) {
+	db, err := NewDatabase(Config{Path: filepath.Join(t.TempDir(), "hifi-baby.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	records := historyRecords(2*importBatchSize + 10)
+
+	// The player records listens while the history is read
+	result, err := db.ImportHistory(recordReader(records, func(idx int) error {
+		if idx%importBatchSize != 0 {
+			return nil
+		}
+		return db.orm.Omit("TrackID", "ProfileID").Create(&ListenedTrack{
+			TrackName: "live.mp3",
+			At:        time.Now(),
+			During:    10,
+		}).Error
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != len(records) || result.Duplicates != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if count := countListens(t, db); count != int64(len(records)+3) {
+		t.Fatalf("expected %d listened tracks, got %d", len(records)+3, count)
+	}
+
+	// Importing the history again only finds duplicates
+	result, err = db.ImportHistory(recordReader(records, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 0 || result.Duplicates != len(records) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	listens, err := db.ListenedTracks(uuid.Nil, time.Time{}, Cursor{}, 1)
+	if err != nil || len(listens) != 1 || listens[0].TrackName != "live.mp3" {
+		t.Fatalf("expected the live listen first, got %+v (%v)", listens, err)
+	}
+}
+
+func TestImportHistoryInvalid(t *testing.T) {
+	db, err := NewDatabase(Config{Path: filepath.Join(t.TempDir(), "hifi-baby.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A record invalid after the first batches imports nothing
+	errInvalid := errors.New("invalid record")
+	records := historyRecords(2*importBatchSize + 10)
+	_, err = db.ImportHistory(recordReader(records, func(idx int) error {
+		if idx == len(records)-1 {
+			return errInvalid
+		}
+		return nil
+	}))
+	if !errors.Is(err, errInvalid) {
+		t.Fatalf("expected the record error, got %v", err)
+	}
+	if count := countListens(t, db); count != 0 {
+		t.Fatalf("expected no listened track, got %d", count)
+	}
+}
@@ -0,0 +1,290 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// ErrDatabaseTooNew is returned when the database was migrated by a newer program.
+var ErrDatabaseTooNew = errors.New("database schema is newer than this program")
+
+// migration upgrades the database schema to its version.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// migrations are applied in order, each one in its own transaction.
+// Applied migrations must never be modified: changes go in a new migration.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create listened tracks",
+		up: execAll(
+			// Databases created before the migrations already have this table
+			"CREATE TABLE IF NOT EXISTS `listened_tracks` ("+
+				"`id` integer PRIMARY KEY AUTOINCREMENT, `created_at` datetime, `updated_at` datetime, "+
+				"`deleted_at` datetime, `track_name` text, `at` datetime, `during` integer)",
+			"CREATE INDEX IF NOT EXISTS `idx_listened_tracks_deleted_at` ON `listened_tracks`(`deleted_at`)",
+		),
+	},
+	{
+		version: 2,
+		name:    "create tracks and link listened tracks",
+		up: func(tx *gorm.DB) error {
+			err := execAll(
+				"CREATE TABLE IF NOT EXISTS `tracks` ("+
+					"`id` uuid, `path` text, `name` text, `format` text, `fingerprint` text, "+
+					"`created_at` datetime, `updated_at` datetime, PRIMARY KEY (`id`))",
+				"CREATE UNIQUE INDEX IF NOT EXISTS `idx_tracks_path` ON `tracks`(`path`)",
+				"CREATE INDEX IF NOT EXISTS `idx_tracks_fingerprint` ON `tracks`(`fingerprint`)",
+			)(tx)
+			if err != nil {
+				return err
+			}
+
+			if err := addColumn(tx, "listened_tracks", "track_id", "uuid"); err != nil {
+				return err
+			}
+
+			return tx.Exec("CREATE INDEX IF NOT EXISTS `idx_listened_tracks_track_id` " +
+				"ON `listened_tracks`(`track_id`)").Error
+		},
+	},
+	{
+		version: 3,
+		name:    "track how listened tracks started and ended",
+		up: func(tx *gorm.DB) error {
+			columns := []struct{ name, columnType string }{
+				{"source", "text"},
+				{"end_reason", "text"},
+				{"start_position", "integer"},
+				{"end_position", "integer"},
+				{"track_duration", "integer"},
+			}
+			for _, column := range columns {
+				if err := addColumn(tx, "listened_tracks", column.name, column.columnType); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 4,
+		name:    "create daily listens",
+		up: execAll(
+			"CREATE TABLE IF NOT EXISTS `daily_listens` ("+
+				"`date` text NOT NULL, `track_key` text NOT NULL, `track_id` uuid, `track_name` text, "+
+				"`during` integer NOT NULL DEFAULT 0, `count` integer NOT NULL DEFAULT 0, "+
+				"`skipped` integer NOT NULL DEFAULT 0, `completions` real NOT NULL DEFAULT 0, "+
+				"PRIMARY KEY (`date`, `track_key`))",
+			"CREATE INDEX IF NOT EXISTS `idx_listened_tracks_at` ON `listened_tracks`(`at`)",
+		),
+	},
+	{
+		version: 5,
+		name:    "add track ratings",
+		up: func(tx *gorm.DB) error {
+			if err := addColumn(tx, "tracks", "favourite", "numeric NOT NULL DEFAULT false"); err != nil {
+				return err
+			}
+			return addColumn(tx, "tracks", "rating", "integer NOT NULL DEFAULT 0")
+		},
+	},
+	{
+		version: 6,
+		name:    "add track availability",
+		up: func(tx *gorm.DB) error {
+			columns := []struct{ name, columnType string }{
+				{"hidden", "numeric NOT NULL DEFAULT false"},
+				{"disabled_until", "datetime"},
+				{"disabled_windows", "text"},
+			}
+			for _, column := range columns {
+				if err := addColumn(tx, "tracks", column.name, column.columnType); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 7,
+		name:    "create profiles",
+		up: func(tx *gorm.DB) error {
+			err := execAll(
+				"CREATE TABLE IF NOT EXISTS `profiles` ("+
+					"`id` uuid PRIMARY KEY, `name` text NOT NULL, `collections` text, "+
+					"`daily_quota` integer NOT NULL DEFAULT 0, `schedule` text, `settings` text, "+
+					"`active` numeric NOT NULL DEFAULT false, `created_at` datetime, `updated_at` datetime)",
+				"CREATE UNIQUE INDEX IF NOT EXISTS `idx_profiles_name` ON `profiles`(`name`)",
+			)(tx)
+			if err != nil {
+				return err
+			}
+			if err := addColumn(tx, "listened_tracks", "profile_id", "uuid"); err != nil {
+				return err
+			}
+			return tx.Exec("CREATE INDEX IF NOT EXISTS `idx_listened_tracks_profile_id` " +
+				"ON `listened_tracks`(`profile_id`)").Error
+		},
+	},
+	{
+		version: 8,
+		name:    "add profiles to daily listens",
+		// The primary key changes: the table is rebuilt, the listens already
+		// rolled up being kept without profile
+		up: execAll(
+			"CREATE TABLE `daily_listens_v8` ("+
+				"`date` text NOT NULL, `profile_key` text NOT NULL DEFAULT '', `profile_id` uuid, "+
+				"`track_key` text NOT NULL, `track_id` uuid, `track_name` text, "+
+				"`during` integer NOT NULL DEFAULT 0, `count` integer NOT NULL DEFAULT 0, "+
+				"`skipped` integer NOT NULL DEFAULT 0, `completions` real NOT NULL DEFAULT 0, "+
+				"PRIMARY KEY (`date`, `profile_key`, `track_key`))",
+			"INSERT INTO `daily_listens_v8` "+
+				"(`date`, `track_key`, `track_id`, `track_name`, `during`, `count`, `skipped`, `completions`) "+
+				"SELECT `date`, `track_key`, `track_id`, `track_name`, `during`, `count`, `skipped`, `completions` "+
+				"FROM `daily_listens`",
+			"DROP TABLE `daily_listens`",
+			"ALTER TABLE `daily_listens_v8` RENAME TO `daily_listens`",
+		),
+	},
+}
+
+// execAll returns a migration step executing the statements in order.
+func execAll(statements ...string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn adds the column unless it already exists, as in the databases
+// migrated with gorm AutoMigrate before the versioned migrations.
+func addColumn(tx *gorm.DB, table, column, columnType string) error {
+	var count int64
+	err := tx.Raw("SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, column).
+		Scan(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+
+	return tx.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", table, column, columnType)).Error
+}
+
+// schemaVersion lists the applied migrations.
+type schemaVersion struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// latestVersion returns the schema version expected by this program.
+func latestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate applies the pending migrations, after a backup of the database file.
+func migrate(orm *gorm.DB, path string) error {
+	err := orm.Exec("CREATE TABLE IF NOT EXISTS `schema_version` (" +
+		"`version` integer PRIMARY KEY, `name` text NOT NULL, `applied_at` datetime NOT NULL)").Error
+	if err != nil {
+		return fmt.Errorf("failed to create schema version table: %w", err)
+	}
+
+	current, err := currentVersion(orm)
+	if err != nil {
+		return err
+	}
+
+	latest := latestVersion()
+	if current > latest {
+		return fmt.Errorf("%w: version %d, expected at most %d", ErrDatabaseTooNew, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	if err := backupBeforeMigration(orm, path, current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		log.Info().Msgf("Migrating database to version %d: %s", m.version, m.name)
+		err := orm.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaVersion{
+				Version:   m.version,
+				Name:      m.name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate database to version %d (%s): %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// currentVersion returns the version of the last applied migration, 0 if none.
+func currentVersion(orm *gorm.DB) (int, error) {
+	var version int
+	err := orm.Raw("SELECT COALESCE(MAX(version), 0) FROM `schema_version`").Scan(&version).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// backupBeforeMigration copies the database next to its file before it is migrated.
+// Empty databases, being created, are not backed up.
+func backupBeforeMigration(orm *gorm.DB, path string, version int) error {
+	var tables int64
+	err := orm.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'table' " +
+		"AND name NOT IN ('schema_version', 'sqlite_sequence')").Scan(&tables).Error
+	if err != nil {
+		return fmt.Errorf("failed to inspect database: %w", err)
+	}
+	if tables == 0 || path == "" || strings.HasPrefix(path, ":memory:") || strings.HasPrefix(path, "file:") {
+		return nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102-150405"))
+	if err := vacuumInto(orm, backupPath); err != nil {
+		return fmt.Errorf("failed to back up database before migration: %w", err)
+	}
+
+	log.Info().Msgf("Database backed up to %s before migration", backupPath)
+	return nil
+}
+
+// vacuumInto writes a consistent copy of the database to a new file.
+func vacuumInto(orm *gorm.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("file %q already exists", path)
+	}
+	return orm.Exec("VACUUM INTO ?", path).Error
+}
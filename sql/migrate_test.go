@@ -0,0 +1,361 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The IDs of the track and of the profile in the fixtures.
+var (
+	fixtureTrackID   = uuid.MustParse("6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6")
+	fixtureProfileID = uuid.MustParse("0a1b2c3d-4e5f-4a6b-8c7d-8e9f0a1b2c3d")
+)
+
+// openTestDatabase opens the database file without migrating it.
+func openTestDatabase(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+
+	orm, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if conn, err := orm.DB(); err == nil {
+			conn.Close()
+		}
+	})
+	return orm
+}
+
+// createFixture creates a database at the given schema version, filled with
+// testdata/migrate/v<version>.sql. Version 0 is a database created before the
+// versioned migrations.
+func createFixture(t *testing.T, path string, version int) {
+	t.Helper()
+
+	orm := openTestDatabase(t, path)
+	if version > 0 {
+		err := orm.Exec("CREATE TABLE `schema_version` (" +
+			"`version` integer PRIMARY KEY, `name` text NOT NULL, `applied_at` datetime NOT NULL)").Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, m := range migrations {
+		if m.version > version {
+			break
+		}
+		if err := m.up(orm); err != nil {
+			t.Fatalf("failed to apply migration %d: %v", m.version, err)
+		}
+		if err := orm.Create(&schemaVersion{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "migrate", fmt.Sprintf("v%d.sql", version)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orm.Exec(string(fixture)).Error; err != nil {
+		t.Fatalf("failed to fill database at version %d: %v", version, err)
+	}
+
+	conn, err := orm.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// backups returns the backups made before migrating the database file.
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(path + ".v*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestMigrateFixtures(t *testing.T) {
+	for version := 0; version < latestVersion(); version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hifi-baby.db")
+			createFixture(t, path, version)
+
+			db, err := NewDatabase(Config{Path: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if current, err := db.SchemaVersion(); err != nil || current != latestVersion() {
+				t.Fatalf("expected version %d, got %d (%v)", latestVersion(), current, err)
+			}
+
+			// The database is backed up as it was before the migration
+			files := backups(t, path)
+			if len(files) != 1 || !strings.HasPrefix(files[0], fmt.Sprintf("%s.v%d-", path, version)) {
+				t.Fatalf("expected one backup of version %d, got %v", version, files)
+			}
+			backup := openTestDatabase(t, files[0])
+			if current, err := currentVersion(backup); err != nil || current != version {
+				t.Fatalf("expected backup at version %d, got %d (%v)", version, current, err)
+			}
+			var count int64
+			if err := backup.Table("listened_tracks").Count(&count).Error; err != nil || count != 1 {
+				t.Fatalf("expected 1 listen in the backup, got %d (%v)", count, err)
+			}
+
+			checkListens(t, db, version)
+			checkTracks(t, db, version)
+			checkProfiles(t, db, version)
+			checkRollup(t, db, version)
+		})
+	}
+}
+
+// checkListens checks the listen of the fixture, with the columns of its version.
+func checkListens(t *testing.T, db *Database, version int) {
+	t.Helper()
+
+	listens, err := db.ListenedTracks(uuid.Nil, time.Time{}, Cursor{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 1 {
+		t.Fatalf("expected 1 listen, got %d", len(listens))
+	}
+
+	listen := listens[0]
+	if listen.TrackName != "lullaby.mp3" || listen.During != 120 {
+		t.Fatalf("unexpected listen %+v", listen)
+	}
+	if expected := idFrom(version, 2, fixtureTrackID); listen.TrackID != expected {
+		t.Fatalf("expected track %s, got %s", expected, listen.TrackID)
+	}
+	if version >= 3 && (listen.Source != "gpio" || listen.EndReason != "finished" || listen.TrackDuration != 120) {
+		t.Fatalf("unexpected listen %+v", listen)
+	}
+	if version < 3 && (listen.EndReason != "" || listen.TrackDuration != 0) {
+		t.Fatalf("expected no end reason nor track duration, got %+v", listen)
+	}
+	if expected := idFrom(version, 7, fixtureProfileID); listen.ProfileID != expected {
+		t.Fatalf("expected profile %s, got %s", expected, listen.ProfileID)
+	}
+}
+
+// checkTracks checks the track of the fixture, with the columns of its version.
+func checkTracks(t *testing.T, db *Database, version int) {
+	t.Helper()
+
+	var tracks []*Track
+	if err := db.orm.Find(&tracks).Error; err != nil {
+		t.Fatal(err)
+	}
+	if version < 2 {
+		if len(tracks) != 0 {
+			t.Fatalf("expected no track, got %d", len(tracks))
+		}
+		return
+	}
+	if len(tracks) != 1 || tracks[0].ID != fixtureTrackID || tracks[0].Path != "tracks/lullaby.mp3" {
+		t.Fatalf("unexpected tracks %+v", tracks)
+	}
+
+	track := tracks[0]
+	if expected := version >= 5; track.Favourite != expected || (track.Rating == 4) != expected {
+		t.Fatalf("unexpected rating of %+v", track)
+	}
+	if expected := version >= 6; track.Hidden != expected {
+		t.Fatalf("unexpected availability of %+v", track)
+	}
+}
+
+// checkProfiles checks the profile of the fixture, from version 7.
+func checkProfiles(t *testing.T, db *Database, version int) {
+	t.Helper()
+
+	profiles, err := db.Profiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version < 7 {
+		if len(profiles) != 0 {
+			t.Fatalf("expected no profile, got %d", len(profiles))
+		}
+		return
+	}
+	if len(profiles) != 1 || profiles[0].ID != fixtureProfileID || profiles[0].Name != "Alice" ||
+		profiles[0].DailyQuota != 30 || len(profiles[0].Collections) != 1 {
+		t.Fatalf("unexpected profiles %+v", profiles)
+	}
+
+	active, err := db.ActiveProfile()
+	if err != nil || active == nil || active.ID != fixtureProfileID {
+		t.Fatalf("expected the active profile, got %+v (%v)", active, err)
+	}
+}
+
+// checkRollup checks the daily listens of the fixture, from version 4, then
+// rolls the listen of the fixture up with the current schema.
+func checkRollup(t *testing.T, db *Database, version int) {
+	t.Helper()
+
+	daily, err := db.DailyListens(uuid.Nil, "2023-06-01", "2023-06-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version < 4 {
+		if len(daily) != 0 {
+			t.Fatalf("expected no daily listen, got %d", len(daily))
+		}
+	} else if len(daily) != 1 || daily[0].TrackID != fixtureTrackID || daily[0].During != 600 ||
+		daily[0].Count != 5 || daily[0].Skipped != 1 || daily[0].Completions != 4.5 {
+		t.Fatalf("unexpected daily listens %+v", daily)
+	}
+
+	// The listens rolled up before the profiles have no profile
+	var keys []string
+	if err := db.orm.Raw("SELECT profile_key FROM `daily_listens`").Scan(&keys).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if key != "" {
+			t.Fatalf("expected no profile in the daily listens, got %q", key)
+		}
+	}
+
+	result, err := db.PruneHistory(time.Date(2025, time.January, 1, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RolledUp != 1 {
+		t.Fatalf("expected 1 listen rolled up, got %d", result.RolledUp)
+	}
+
+	var rolledUp []*DailyListen
+	if err := db.orm.Where("date = ?", "2024-01-02").Find(&rolledUp).Error; err != nil {
+		t.Fatal(err)
+	}
+	expected := ""
+	if version >= 7 {
+		expected = fixtureProfileID.String()
+	}
+	if len(rolledUp) != 1 || rolledUp[0].ProfileKey != expected || rolledUp[0].During != 120 ||
+		rolledUp[0].TrackName != "lullaby.mp3" {
+		t.Fatalf("unexpected rolled up listens %+v", rolledUp)
+	}
+}
+
+// idFrom returns the ID from the version it is stored, uuid.Nil before.
+func idFrom(version, since int, id uuid.UUID) uuid.UUID {
+	if version < since {
+		return uuid.Nil
+	}
+	return id
+}
+
+func TestMigrateLatest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hifi-baby.db")
+
+	// A new database is created without backup
+	db, err := NewDatabase(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, err := db.SchemaVersion(); err != nil || current != latestVersion() {
+		t.Fatalf("expected version %d, got %d (%v)", latestVersion(), current, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := backups(t, path); len(files) != 0 {
+		t.Fatalf("expected no backup of a new database, got %v", files)
+	}
+
+	// An up to date database is neither migrated nor backed up
+	db, err = NewDatabase(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := backups(t, path); len(files) != 0 {
+		t.Fatalf("expected no backup of an up to date database, got %v", files)
+	}
+}
+
+func TestMigrateTooNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hifi-baby.db")
+	createFixture(t, path, latestVersion()-1)
+
+	orm := openTestDatabase(t, path)
+	err := orm.Create(&schemaVersion{Version: latestVersion() + 1, Name: "future", AppliedAt: time.Now()}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabase(Config{Path: path})
+	if !errors.Is(err, ErrDatabaseTooNew) {
+		if db != nil {
+			db.Close()
+		}
+		t.Fatalf("expected ErrDatabaseTooNew, got %v", err)
+	}
+	if files := backups(t, path); len(files) != 0 {
+		t.Fatalf("expected no backup of a newer database, got %v", files)
+	}
+}
+
+func TestBackupBeforeMigration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hifi-baby.db")
+	createFixture(t, path, 1)
+	orm := openTestDatabase(t, path)
+
+	// In-memory databases and URIs are not backed up
+	for _, other := range []string{"", ":memory:", "file:" + path} {
+		if err := backupBeforeMigration(orm, other, 1); err != nil {
+			t.Fatalf("unexpected error for %q: %v", other, err)
+		}
+	}
+	if files := backups(t, path); len(files) != 0 {
+		t.Fatalf("expected no backup, got %v", files)
+	}
+
+	if err := backupBeforeMigration(orm, path, 1); err != nil {
+		t.Fatal(err)
+	}
+	files := backups(t, path)
+	if len(files) != 1 {
+		t.Fatalf("expected one backup, got %v", files)
+	}
+
+	var count int64
+	if err := openTestDatabase(t, files[0]).Table("listened_tracks").Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("expected 1 listen in the backup, got %d (%v)", count, err)
+	}
+
+	// VACUUM INTO never overwrites a file
+	if err := vacuumInto(orm, files[0]); err == nil {
+		t.Fatal("expected an error backing up to an existing file")
+	}
+}
@@ -0,0 +1,175 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+)
+
+// Profile is the persistent profile of a child, at most one being active.
+type Profile struct {
+	ID          uuid.UUID             `gorm:"type:uuid;primaryKey"`
+	Name        string                `gorm:"uniqueIndex"`
+	Collections []string              `gorm:"serializer:json"`
+	DailyQuota  int                   // DailyQuota is the listening time allowed per day in minutes, 0 for no limit.
+	Schedule    []audio.TimeWindow    `gorm:"serializer:json"`
+	Settings    audio.ProfileSettings `gorm:"serializer:json"`
+	Active      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// toAudio returns the profile as used by the audio manager.
+func (p *Profile) toAudio() *audio.Profile {
+	return &audio.Profile{
+		ID:          p.ID,
+		Name:        p.Name,
+		Collections: p.Collections,
+		DailyQuota:  p.DailyQuota,
+		Schedule:    p.Schedule,
+		Settings:    p.Settings,
+	}
+}
+
+// Profiles returns the profiles sorted by name.
+func (db *Database) Profiles() ([]*audio.Profile, error) {
+	var stored []*Profile
+	if err := db.orm.Order("name").Find(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to get profiles: %w", err)
+	}
+
+	profiles := make([]*audio.Profile, 0, len(stored))
+	for _, profile := range stored {
+		profiles = append(profiles, profile.toAudio())
+	}
+	return profiles, nil
+}
+
+// Profile returns the profile matching the given ID.
+func (db *Database) Profile(id uuid.UUID) (*audio.Profile, error) {
+	var stored Profile
+	err := db.orm.Where("id = ?", id).First(&stored).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, fmt.Errorf("%w: %q", audio.ErrProfileNotFound, id)
+	case err != nil:
+		return nil, fmt.Errorf("failed to get profile %q: %w", id, err)
+	}
+	return stored.toAudio(), nil
+}
+
+// ActiveProfile returns the active profile, nil if none.
+func (db *Database) ActiveProfile() (*audio.Profile, error) {
+	var stored Profile
+	err := db.orm.Where("active").First(&stored).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to get active profile: %w", err)
+	}
+	return stored.toAudio(), nil
+}
+
+// ActivateProfile makes the given profile the active one and returns it, or
+// deactivates the profiles for uuid.Nil.
+func (db *Database) ActivateProfile(id uuid.UUID) (*audio.Profile, error) {
+	var profile *audio.Profile
+	err := db.orm.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Profile{}).Where("active").Update("active", false).Error
+		if err != nil || id == uuid.Nil {
+			return err
+		}
+
+		var stored Profile
+		err = tx.Where("id = ?", id).First(&stored).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %q", audio.ErrProfileNotFound, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		stored.Active = true
+		if err := tx.Save(&stored).Error; err != nil {
+			return err
+		}
+		profile = stored.toAudio()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to activate profile %q: %w", id, err)
+	}
+	return profile, nil
+}
+
+// SaveProfile creates the profile, assigning it an ID, or updates it.
+func (db *Database) SaveProfile(profile *audio.Profile) error {
+	return db.orm.Transaction(func(tx *gorm.DB) error {
+		stored := Profile{ID: uuid.New()}
+		if profile.ID != uuid.Nil {
+			err := tx.Where("id = ?", profile.ID).First(&stored).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: %q", audio.ErrProfileNotFound, profile.ID)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get profile %q: %w", profile.ID, err)
+			}
+		}
+
+		var duplicates int64
+		err := tx.Model(&Profile{}).Where("name = ? AND id <> ?", profile.Name, stored.ID).Count(&duplicates).Error
+		if err != nil {
+			return fmt.Errorf("failed to check profile %q: %w", profile.Name, err)
+		}
+		if duplicates > 0 {
+			return fmt.Errorf("%w: name %q already used", audio.ErrInvalidProfile, profile.Name)
+		}
+
+		stored.Name = profile.Name
+		stored.Collections = profile.Collections
+		stored.DailyQuota = profile.DailyQuota
+		stored.Schedule = profile.Schedule
+		stored.Settings = profile.Settings
+		if err := tx.Save(&stored).Error; err != nil {
+			return fmt.Errorf("failed to save profile %q: %w", profile.Name, err)
+		}
+
+		profile.ID = stored.ID
+		return nil
+	})
+}
+
+// DeleteProfile deletes the profile, keeping its listening history.
+func (db *Database) DeleteProfile(id uuid.UUID) error {
+	result := db.orm.Where("id = ?", id).Delete(&Profile{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete profile %q: %w", id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %q", audio.ErrProfileNotFound, id)
+	}
+	return nil
+}
+
+// ListeningTime returns the time the profile has spent listening since the
+// given time, including the days rolled up by the retention policy.
+func (db *Database) ListeningTime(profileID uuid.UUID, since time.Time) (time.Duration, error) {
+	var seconds int64
+	err := db.orm.Raw("SELECT "+
+		"(SELECT COALESCE(sum(during), 0) FROM `listened_tracks` "+
+		// Times are stored as text in the local time zone of the box
+		"WHERE deleted_at IS NULL AND profile_id = ? AND at >= ?) + "+
+		"(SELECT COALESCE(sum(during), 0) FROM `daily_listens` WHERE profile_key = ? AND date >= ?)",
+		profileID, since.Local(), profileID.String(), rolledUpDate(since)).
+		Scan(&seconds).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to get listening time of profile %q: %w", profileID, err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,118 @@
+package sql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// DailyListen sums up the listens of a track during a day, once the listened
+// tracks are older than the retention period.
+type DailyListen struct {
+	Date        string    `gorm:"primaryKey" json:"date"` // Date is the day in the local time zone, formatted as 2006-01-02.
+	ProfileKey  string    `gorm:"primaryKey" json:"-"`    // ProfileKey is the profile ID, empty for the listens without profile.
+	TrackKey    string    `gorm:"primaryKey" json:"-"`    // TrackKey is the track ID, or the track name for unlinked listens.
+	ProfileID   uuid.UUID `gorm:"type:uuid" json:"profile_id"`
+	TrackID     uuid.UUID `gorm:"type:uuid" json:"track_id"`
+	TrackName   string    `json:"track_name"`
+	During      int64     `json:"during"`      // During is the listening time in seconds.
+	Count       int       `json:"count"`       // Count is the number of listens.
+	Skipped     int       `json:"skipped"`     // Skipped is the number of skipped listens.
+	Completions float64   `json:"completions"` // Completions is the number of listens weighted by their completion ratio.
+}
+
+// PruneResult tells what the retention policy removed.
+type PruneResult struct {
+	RolledUp int64 // RolledUp is the number of listened tracks rolled up into daily listens.
+}
+
+// PruneHistory rolls the listened tracks started before the given time up into
+// daily listens, then deletes them. Listens of days already rolled up, such as
+// imported ones, are added to the existing daily listens.
+func (db *Database) PruneHistory(before time.Time) (*PruneResult, error) {
+	result := &PruneResult{}
+
+	err := db.orm.Transaction(func(tx *gorm.DB) error {
+		// Times are stored as text in the local time zone of the box: the first
+		// 10 characters are the local date
+		err := tx.Exec("INSERT INTO `daily_listens` "+
+			"(`date`, `profile_key`, `track_key`, `profile_id`, `track_id`, `track_name`, "+
+			"`during`, `count`, `skipped`, `completions`) "+
+			"SELECT substr(listened_tracks.at, 1, 10), COALESCE(listened_tracks.profile_id, ''), "+
+			"COALESCE(listened_tracks.track_id, 'name:' || listened_tracks.track_name), "+
+			"listened_tracks.profile_id, listened_tracks.track_id, COALESCE(tracks.name, listened_tracks.track_name), "+
+			"sum(listened_tracks.during), count(*), "+
+			"sum(CASE WHEN listened_tracks.end_reason = 'skipped' THEN 1 ELSE 0 END), "+
+			"COALESCE(sum("+completionRatio+"), 0) "+
+			"FROM `listened_tracks` LEFT JOIN `tracks` ON tracks.id = listened_tracks.track_id "+
+			"WHERE listened_tracks.at < ? "+
+			"GROUP BY 1, 2, 3 "+
+			"ON CONFLICT (`date`, `profile_key`, `track_key`) DO UPDATE SET "+
+			"`track_name` = excluded.track_name, "+
+			"`during` = `during` + excluded.during, "+
+			"`count` = `count` + excluded.count, "+
+			"`skipped` = `skipped` + excluded.skipped, "+
+			"`completions` = `completions` + excluded.completions",
+			before.Local()).Error
+		if err != nil {
+			return fmt.Errorf("failed to roll up listened tracks: %w", err)
+		}
+
+		deleted := tx.Unscoped().Where("at < ?", before.Local()).Delete(&ListenedTrack{})
+		if deleted.Error != nil {
+			return fmt.Errorf("failed to delete rolled up listened tracks: %w", deleted.Error)
+		}
+		result.RolledUp = deleted.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The file only shrinks once vacuumed
+	if result.RolledUp > 0 {
+		if err := db.orm.Exec("VACUUM").Error; err != nil {
+			log.Warn().Err(err).Msg("Failed to vacuum the database")
+		}
+	}
+
+	return result, nil
+}
+
+// DailyListens gets the daily listens of the profile, or of all the profiles
+// summed up for uuid.Nil, between the given dates, formatted as 2006-01-02.
+func (db *Database) DailyListens(profileID uuid.UUID, from, to string) ([]*DailyListen, error) {
+	var listens []*DailyListen
+	query := db.orm.Model(&DailyListen{})
+	if profileID != uuid.Nil {
+		query = query.Where("profile_key = ?", profileID.String())
+	} else {
+		query = query.
+			Select("date, track_key, track_id, track_name, sum(during) AS during, sum(count) AS count, " +
+				"sum(skipped) AS skipped, sum(completions) AS completions").
+			Group("date, track_key")
+	}
+	query = query.
+		Where("date >= ? AND date <= ?", from, to).
+		Order("date").
+		Order("during DESC").
+		Find(&listens)
+	if err := query.Error; err != nil {
+		return nil, fmt.Errorf("failed to get daily listens: %w", err)
+	}
+	return listens, nil
+}
+
+// rolledUpDate returns the first date, in the local time zone, whose daily
+// listens start at or after the given time.
+func rolledUpDate(t time.Time) string {
+	t = t.Local()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	if day.Before(t) {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day.Format(time.DateOnly)
+}
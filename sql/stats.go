@@ -0,0 +1,369 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+)
+
+const (
+	// sessionGap is the longest pause between two listens of the same session.
+	sessionGap = 10 * time.Minute
+	// skipThreshold is the listen duration below which a track is considered
+	// skipped, for the listens recorded without their end reason.
+	skipThreshold = 30 * time.Second
+	// maxDailyStatsDays is the largest number of days returned by DailyStats.
+	maxDailyStatsDays = 10 * 366
+)
+
+// StatsRange bounds the listens taken into account, buckets being computed
+// in the time zone of the location.
+type StatsRange struct {
+	From     time.Time
+	To       time.Time
+	Location *time.Location
+	Profile  uuid.UUID // Profile restricts the listens to a profile, all of them for uuid.Nil.
+}
+
+// listen is a listened track interval.
+type listen struct {
+	trackID   uuid.UUID
+	trackName string
+	endReason audio.EndReason
+	start     time.Time
+	end       time.Time
+}
+
+// forEachListen calls fn with the listens started within the range, in
+// chronological order, clipped to the range. Rows are read one at a time.
+func (db *Database) forEachListen(r StatsRange, fn func(l *listen)) error {
+	query := db.orm.Model(&ListenedTrack{})
+	if r.Profile != uuid.Nil {
+		query = query.Where("listened_tracks.profile_id = ?", r.Profile)
+	}
+	rows, err := query.
+		Select("listened_tracks.track_id, "+
+			"COALESCE(tracks.name, listened_tracks.track_name), "+
+			"COALESCE(listened_tracks.end_reason, ''), listened_tracks.at, listened_tracks.during").
+		Joins("LEFT JOIN tracks ON tracks.id = listened_tracks.track_id").
+		// Times are stored as text in the local time zone of the box
+		Where("listened_tracks.at >= ? AND listened_tracks.at < ?", r.From.Local(), r.To.Local()).
+		Order("listened_tracks.at").
+		Rows()
+	if err != nil {
+		return fmt.Errorf("failed to get listened tracks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			l      listen
+			during int64
+		)
+		if err := rows.Scan(&l.trackID, &l.trackName, &l.endReason, &l.start, &during); err != nil {
+			return fmt.Errorf("failed to read listened track: %w", err)
+		}
+
+		l.start = l.start.In(r.Location)
+		l.end = l.start.Add(time.Duration(during) * time.Second)
+		if l.end.After(r.To) {
+			l.end = r.To
+		}
+		fn(&l)
+	}
+
+	return rows.Err()
+}
+
+// rolledUpDay is the listening of a track during a day, rolled up by the
+// retention policy.
+type rolledUpDay struct {
+	trackID   uuid.UUID
+	trackName string
+	day       time.Time // day is the start of the day, in the time zone of the range.
+	during    int64
+	count     int
+	skipped   int
+}
+
+// forEachRolledUpDay calls fn with the daily listens of the days starting
+// within the range, in chronological order.
+func (db *Database) forEachRolledUpDay(r StatsRange, fn func(d *rolledUpDay)) error {
+	query := db.orm.Model(&DailyListen{})
+	if r.Profile != uuid.Nil {
+		query = query.Where("daily_listens.profile_key = ?", r.Profile.String())
+	}
+	rows, err := query.
+		Select("daily_listens.track_id, COALESCE(tracks.name, daily_listens.track_name), "+
+			"daily_listens.date, daily_listens.during, daily_listens.count, daily_listens.skipped").
+		Joins("LEFT JOIN tracks ON tracks.id = daily_listens.track_id").
+		Where("daily_listens.date >= ? AND daily_listens.date < ?", rolledUpDate(r.From), rolledUpDate(r.To)).
+		Order("daily_listens.date").
+		Rows()
+	if err != nil {
+		return fmt.Errorf("failed to get daily listens: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			d    rolledUpDay
+			date string
+		)
+		if err := rows.Scan(&d.trackID, &d.trackName, &date, &d.during, &d.count, &d.skipped); err != nil {
+			return fmt.Errorf("failed to read daily listen: %w", err)
+		}
+
+		day, err := time.ParseInLocation(time.DateOnly, date, time.Local)
+		if err != nil {
+			return fmt.Errorf("failed to read daily listen date %q: %w", date, err)
+		}
+		d.day = day.In(r.Location)
+		fn(&d)
+	}
+
+	return rows.Err()
+}
+
+// skipped tells whether the listen was cut by another track.
+func (l *listen) skipped() bool {
+	if l.endReason == "" {
+		return l.end.Sub(l.start) < skipThreshold
+	}
+	return l.endReason == audio.EndSkipped
+}
+
+// splitByHour calls fn with the parts of the listen within each hour of its time zone.
+func (l *listen) splitByHour(fn func(start time.Time, duration time.Duration)) {
+	for start := l.start; start.Before(l.end); {
+		end := time.Date(start.Year(), start.Month(), start.Day(), start.Hour()+1, 0, 0, 0, start.Location())
+		if !end.After(start) {
+			// Daylight saving time change
+			end = start.Add(time.Hour)
+		}
+		if end.After(l.end) {
+			end = l.end
+		}
+		fn(start, end.Sub(start))
+		start = end
+	}
+}
+
+// DailyStat is the listening time of a day.
+type DailyStat struct {
+	Date   string `json:"date"`   // Date is the day, formatted as 2006-01-02.
+	During int64  `json:"during"` // During is the listening time in seconds.
+	Count  int    `json:"count"`  // Count is the number of listens started that day.
+}
+
+// DailyStats returns the listening time of every day of the range, including
+// the days without listens and the days rolled up by the retention policy.
+// Ranges longer than 10 years are clamped to their last 10 years.
+func (db *Database) DailyStats(r StatsRange) ([]*DailyStat, error) {
+	if earliest := r.To.AddDate(0, 0, -maxDailyStatsDays); r.From.Before(earliest) {
+		r.From = earliest
+	}
+
+	var days []*DailyStat
+	byDate := make(map[string]*DailyStat)
+
+	from := r.From.In(r.Location)
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, r.Location)
+	for ; day.Before(r.To); day = day.AddDate(0, 0, 1) {
+		stat := &DailyStat{Date: day.Format(time.DateOnly)}
+		days = append(days, stat)
+		byDate[stat.Date] = stat
+	}
+
+	err := db.forEachRolledUpDay(r, func(d *rolledUpDay) {
+		if stat, ok := byDate[d.day.Format(time.DateOnly)]; ok {
+			stat.During += d.during
+			stat.Count += d.count
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.forEachListen(r, func(l *listen) {
+		if stat, ok := byDate[l.start.Format(time.DateOnly)]; ok {
+			stat.Count++
+		}
+		l.splitByHour(func(start time.Time, duration time.Duration) {
+			if stat, ok := byDate[start.Format(time.DateOnly)]; ok {
+				stat.During += int64(duration.Seconds())
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
+
+// Heatmap is the listening time in seconds by weekday and hour of the day.
+// Weekdays are indexed from Sunday (0) to Saturday (6).
+type Heatmap struct {
+	During   [7][24]int64 `json:"during"`
+	RolledUp [7]int64     `json:"rolled_up"` // RolledUp is the listening time by weekday of the days rolled up by the retention policy, whose hours are not kept.
+}
+
+// HeatmapStats returns the listening time by weekday and hour over the range.
+func (db *Database) HeatmapStats(r StatsRange) (*Heatmap, error) {
+	heatmap := &Heatmap{}
+
+	err := db.forEachRolledUpDay(r, func(d *rolledUpDay) {
+		heatmap.RolledUp[d.day.Weekday()] += d.during
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.forEachListen(r, func(l *listen) {
+		l.splitByHour(func(start time.Time, duration time.Duration) {
+			heatmap.During[start.Weekday()][start.Hour()] += int64(duration.Seconds())
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return heatmap, nil
+}
+
+// TrackStat is the listening trend of a track.
+type TrackStat struct {
+	TrackID   uuid.UUID    `json:"track_id"`
+	TrackName string       `json:"track_name"`
+	During    int64        `json:"during"` // During is the total listening time in seconds.
+	Count     int          `json:"count"`  // Count is the number of listens.
+	Days      []*DailyStat `json:"days"`   // Days are the days the track was listened to.
+}
+
+// TrackStats returns the listening time of each track per day over the range,
+// most listened first, including the days rolled up by the retention policy.
+func (db *Database) TrackStats(r StatsRange) ([]*TrackStat, error) {
+	var tracks []*TrackStat
+	byTrack := make(map[string]*TrackStat)
+
+	add := func(trackID uuid.UUID, trackName string, date string, during int64, count int) {
+		// History not linked to a track is grouped by name
+		key := trackID.String()
+		if trackID == uuid.Nil {
+			key = "name:" + trackName
+		}
+
+		track, ok := byTrack[key]
+		if !ok {
+			track = &TrackStat{TrackID: trackID, TrackName: trackName}
+			byTrack[key] = track
+			tracks = append(tracks, track)
+		}
+
+		if len(track.Days) == 0 || track.Days[len(track.Days)-1].Date != date {
+			track.Days = append(track.Days, &DailyStat{Date: date})
+		}
+		day := track.Days[len(track.Days)-1]
+
+		day.During += during
+		day.Count += count
+		track.During += during
+		track.Count += count
+	}
+
+	err := db.forEachRolledUpDay(r, func(d *rolledUpDay) {
+		add(d.trackID, d.trackName, d.day.Format(time.DateOnly), d.during, d.count)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.forEachListen(r, func(l *listen) {
+		add(l.trackID, l.trackName, l.start.Format(time.DateOnly), int64(l.end.Sub(l.start).Seconds()), 1)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		return tracks[i].During > tracks[j].During
+	})
+	// Listens imported before the retention period may precede rolled up days
+	for _, track := range tracks {
+		sort.SliceStable(track.Days, func(i, j int) bool {
+			return track.Days[i].Date < track.Days[j].Date
+		})
+	}
+
+	return tracks, nil
+}
+
+// SummaryStats sums up the listening sessions over the range. A session is
+// a series of listens separated by pauses shorter than 10 minutes.
+type SummaryStats struct {
+	During         int64   `json:"during"`          // During is the total listening time in seconds.
+	Listens        int     `json:"listens"`         // Listens is the number of listened tracks.
+	Sessions       int     `json:"sessions"`        // Sessions is the number of listening sessions.
+	AverageSession int64   `json:"average_session"` // AverageSession is the average session length in seconds.
+	SkipRate       float64 `json:"skip_rate"`       // SkipRate is the ratio of skipped tracks.
+}
+
+// SummaryStats returns the total listening time, the sessions and the skip rate
+// over the range. The days rolled up by the retention policy count in the
+// listening time and the skip rate, but not in the sessions.
+func (db *Database) SummaryStats(r StatsRange) (*SummaryStats, error) {
+	summary := &SummaryStats{}
+
+	var (
+		sessionsDuration time.Duration
+		sessionStart     time.Time
+		sessionEnd       time.Time
+		skipped          int
+	)
+
+	err := db.forEachRolledUpDay(r, func(d *rolledUpDay) {
+		summary.During += d.during
+		summary.Listens += d.count
+		skipped += d.skipped
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.forEachListen(r, func(l *listen) {
+		duration := l.end.Sub(l.start)
+		summary.During += int64(duration.Seconds())
+		summary.Listens++
+		if l.skipped() {
+			skipped++
+		}
+
+		if summary.Sessions == 0 || l.start.Sub(sessionEnd) > sessionGap {
+			if summary.Sessions > 0 {
+				sessionsDuration += sessionEnd.Sub(sessionStart)
+			}
+			summary.Sessions++
+			sessionStart, sessionEnd = l.start, l.end
+		} else if l.end.After(sessionEnd) {
+			sessionEnd = l.end
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if summary.Sessions > 0 {
+		sessionsDuration += sessionEnd.Sub(sessionStart)
+		summary.AverageSession = int64(sessionsDuration.Seconds()) / int64(summary.Sessions)
+	}
+	if summary.Listens > 0 {
+		summary.SkipRate = float64(skipped) / float64(summary.Listens)
+	}
+
+	return summary, nil
+}
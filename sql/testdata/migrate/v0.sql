@@ -0,0 +1,5 @@
+-- Database created by gorm AutoMigrate, before the versioned migrations.
+CREATE TABLE `listened_tracks` (`id` integer PRIMARY KEY AUTOINCREMENT, `created_at` datetime, `updated_at` datetime, `deleted_at` datetime, `track_name` text, `at` datetime, `during` integer);
+CREATE INDEX `idx_listened_tracks_deleted_at` ON `listened_tracks`(`deleted_at`);
+INSERT INTO `listened_tracks` (`created_at`, `updated_at`, `track_name`, `at`, `during`)
+VALUES ('2024-01-02 10:00:00+00:00', '2024-01-02 10:00:00+00:00', 'lullaby.mp3', '2024-01-02 10:00:00+00:00', 120);
@@ -0,0 +1,3 @@
+-- Version 1: listened tracks only.
+INSERT INTO `listened_tracks` (`created_at`, `updated_at`, `track_name`, `at`, `during`)
+VALUES ('2024-01-02 10:00:00+00:00', '2024-01-02 10:00:00+00:00', 'lullaby.mp3', '2024-01-02 10:00:00+00:00', 120);
@@ -0,0 +1,8 @@
+-- Version 4: daily listens rolled up by the retention policy.
+INSERT INTO `tracks` (`id`, `path`, `name`, `format`, `fingerprint`, `created_at`, `updated_at`)
+VALUES ('6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6', 'tracks/lullaby.mp3', 'lullaby.mp3', 'mp3', 'abc123', '2024-01-02 10:00:00+00:00', '2024-01-02 10:00:00+00:00');
+INSERT INTO `listened_tracks` (`created_at`, `updated_at`, `track_id`, `track_name`, `at`, `during`,
+  `source`, `end_reason`, `start_position`, `end_position`, `track_duration`)
+VALUES ('2024-01-02 10:00:00+00:00', '2024-01-02 10:00:00+00:00', '6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6', 'lullaby.mp3', '2024-01-02 10:00:00+00:00', 120, 'gpio', 'finished', 0, 120, 120);
+INSERT INTO `daily_listens` (`date`, `track_key`, `track_id`, `track_name`, `during`, `count`, `skipped`, `completions`)
+VALUES ('2023-06-01', '6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6', '6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6', 'lullaby.mp3', 600, 5, 1, 4.5);
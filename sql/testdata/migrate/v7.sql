@@ -0,0 +1,11 @@
+-- Version 7: profiles, recorded with the listens.
+INSERT INTO `tracks` (`id`, `path`, `name`, `format`, `fingerprint`, `created_at`, `updated_at`, `favourite`, `rating`,
+  `hidden`, `disabled_until`, `disabled_windows`)
+VALUES ('6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6', 'tracks/lullaby.mp3', 'lullaby.mp3', 'mp3', 'abc123', '2024-01-02 10:00:00+00:00', '2024-01-02 10:00:00+00:00', true, 4, true, NULL, 'null');
+INSERT INTO `profiles` (`id`, `name`, `collections`, `daily_quota`, `schedule`, `settings`, `active`, `created_at`, `updated_at`)
+VALUES ('0a1b2c3d-4e5f-4a6b-8c7d-8e9f0a1b2c3d', 'Alice', '["lullabies"]', 30, 'null', '{}', true, '2024-01-02 10:00:00+00:00', '2024-01-02 10:00:00+00:00');
+INSERT INTO `listened_tracks` (`created_at`, `updated_at`, `track_id`, `profile_id`, `track_name`, `at`, `during`,
+  `source`, `end_reason`, `start_position`, `end_position`, `track_duration`)
+VALUES ('2024-01-02 10:00:00+00:00', '2024-01-02 10:00:00+00:00', '6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6', '0a1b2c3d-4e5f-4a6b-8c7d-8e9f0a1b2c3d', 'lullaby.mp3', '2024-01-02 10:00:00+00:00', 120, 'gpio', 'finished', 0, 120, 120);
+INSERT INTO `daily_listens` (`date`, `track_key`, `track_id`, `track_name`, `during`, `count`, `skipped`, `completions`)
+VALUES ('2023-06-01', '6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6', '6f1c2a3e-8b4d-4c5e-9f60-718293a4b5c6', 'lullaby.mp3', 600, 5, 1, 4.5);
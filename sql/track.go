@@ -0,0 +1,167 @@
+package sql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/OhohLeo/hifi-baby/audio"
+)
+
+// Track is the persistent identity of an audio track.
+// Its ID is assigned once and follows the file when it is renamed or moved.
+type Track struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	Path        string    `gorm:"uniqueIndex" json:"path"`
+	Name        string    `json:"name"`
+	Format      string    `json:"format"`
+	Fingerprint string    `gorm:"index" json:"fingerprint"`
+	Favourite   bool      `json:"favourite"` // Favourite marks the track as a favourite.
+	Rating      int       `json:"rating"`    // Rating rates the track from 1 to 5, 0 if not rated.
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	Hidden          bool               `json:"hidden"`
+	DisabledUntil   *time.Time         `json:"disabled_until"`
+	DisabledWindows []audio.TimeWindow `gorm:"serializer:json" json:"disabled_windows"`
+}
+
+// RegisterTrack assigns the track its persistent ID.
+// The track is first looked up by path, then by fingerprint among the known
+// tracks whose file no longer exists (i.e. it has been renamed or moved).
+// Otherwise a new ID is created.
+func (db *Database) RegisterTrack(track *audio.Track) error {
+	return db.orm.Transaction(func(tx *gorm.DB) error {
+		stored, err := findTrack(tx, track)
+		if err != nil {
+			return err
+		}
+
+		if stored == nil {
+			stored = &Track{ID: uuid.New()}
+		}
+
+		stored.Path = track.Path
+		stored.Name = track.Name
+		stored.Format = track.Format
+		stored.Fingerprint = track.Fingerprint
+		if err := tx.Save(stored).Error; err != nil {
+			return fmt.Errorf("failed to save track %q: %w", track.Path, err)
+		}
+
+		// Link the history recorded by name before tracks had an identity
+		err = tx.Model(&ListenedTrack{}).
+			Where("track_id IS NULL AND track_name = ?", stored.Name).
+			Update("track_id", stored.ID).Error
+		if err != nil {
+			return fmt.Errorf("failed to link history of track %q: %w", track.Path, err)
+		}
+
+		track.ID = stored.ID
+		track.Favourite = stored.Favourite
+		track.Rating = stored.Rating
+		track.AddedAt = stored.CreatedAt
+		track.Hidden = stored.Hidden
+		track.DisabledUntil = stored.DisabledUntil
+		track.DisabledWindows = stored.DisabledWindows
+		return nil
+	})
+}
+
+// findTrack returns the stored track matching the given track, or nil if it is unknown.
+func findTrack(tx *gorm.DB, track *audio.Track) (*Track, error) {
+	var stored Track
+	err := tx.Where("path = ?", track.Path).First(&stored).Error
+	switch {
+	case err == nil:
+		return &stored, nil
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, fmt.Errorf("failed to find track %q: %w", track.Path, err)
+	}
+
+	var candidates []*Track
+	err = tx.Where("fingerprint = ?", track.Fingerprint).
+		Order("updated_at DESC").
+		Find(&candidates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find track %q by fingerprint: %w", track.Path, err)
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate.Path); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RateTrack saves the rating of the track.
+func (db *Database) RateTrack(id uuid.UUID, rating audio.Rating) error {
+	result := db.orm.Model(&Track{}).
+		Where("id = ?", id).
+		// A map also updates the false and zero values
+		Updates(map[string]any{
+			"favourite": rating.Favourite,
+			"rating":    rating.Rating,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to rate track %q: %w", id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %q", audio.ErrTrackNotFound, id)
+	}
+	return nil
+}
+
+// SetTrackAvailability saves when the track may be played.
+func (db *Database) SetTrackAvailability(id uuid.UUID, availability audio.Availability) error {
+	windows, err := json.Marshal(availability.DisabledWindows)
+	if err != nil {
+		return fmt.Errorf("failed to encode disabled windows of track %q: %w", id, err)
+	}
+
+	result := db.orm.Model(&Track{}).
+		Where("id = ?", id).
+		// A map also updates the false and nil values
+		Updates(map[string]any{
+			"hidden":           availability.Hidden,
+			"disabled_until":   availability.DisabledUntil,
+			"disabled_windows": string(windows),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to set availability of track %q: %w", id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %q", audio.ErrTrackNotFound, id)
+	}
+	return nil
+}
+
+// PlayCounts returns the number of listens of each track, rolled up listens included.
+func (db *Database) PlayCounts() (map[uuid.UUID]int, error) {
+	var rows []struct {
+		TrackID uuid.UUID
+		Count   int
+	}
+	err := db.orm.Raw("SELECT track_id, sum(count) AS count FROM (" +
+		"SELECT track_id, count(*) AS count FROM listened_tracks " +
+		"WHERE track_id IS NOT NULL AND deleted_at IS NULL GROUP BY track_id " +
+		"UNION ALL SELECT track_id, sum(count) FROM daily_listens " +
+		"WHERE track_id IS NOT NULL GROUP BY track_id) GROUP BY track_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count track listens: %w", err)
+	}
+
+	counts := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		counts[row.TrackID] = row.Count
+	}
+	return counts, nil
+}